@@ -5,3 +5,7 @@ class CoreConfig(AppConfig):
     default_auto_field = 'django.db.models.BigAutoField'
     name = 'core'
     verbose_name = 'Vídeos'
+
+    def ready(self):
+        from core.tracing import setup_tracing
+        setup_tracing()
@@ -0,0 +1,70 @@
+import json
+from functools import cache
+from pathlib import Path
+
+from django.conf import settings
+from jsonschema import Draft202012Validator
+from jsonschema.exceptions import best_match
+
+# Contratos das mensagens trocadas com o conversor, os schemas ficam em golang/pkg/contracts/schemas
+
+TYPE_HEADER = 'x-message-type'
+VERSION_HEADER = 'x-message-version'
+
+CONVERSION_REQUESTED_V1 = ('conversion.requested', 1)
+CONVERSION_COMPLETED_V1 = ('conversion.completed', 1)
+CONVERSION_FAILED_V1 = ('conversion.failed', 1)
+
+
+class ContractError(Exception):
+    pass
+
+
+class UnknownContractError(ContractError):
+    pass
+
+
+class InvalidMessageError(ContractError):
+    pass
+
+
+def contract_name(contract: tuple[str, int]) -> str:
+    return f'{contract[0]}.v{contract[1]}'
+
+
+def contract_headers(contract: tuple[str, int]) -> dict:
+    return {TYPE_HEADER: contract[0], VERSION_HEADER: str(contract[1])}
+
+
+def contract_from_headers(headers: dict | None, fallback: tuple[str, int]) -> tuple[str, int]:
+    # Mensagens sem tipo são de antes dos contratos versionados; sem versão é a 1, versão ilegível é a 0
+    headers = headers or {}
+    kind = headers.get(TYPE_HEADER)
+    if not isinstance(kind, str) or not kind:
+        return fallback
+    version = headers.get(VERSION_HEADER, 1)
+    try:
+        version = int(version)
+    except (TypeError, ValueError):
+        version = 0
+    return (kind, version)
+
+
+@cache
+def load_validator(contract: tuple[str, int]) -> Draft202012Validator:
+    path = Path(settings.CONTRACT_SCHEMAS_DIR) / f'{contract_name(contract)}.json'
+    try:
+        with open(path) as file:
+            schema = json.load(file)
+    except FileNotFoundError:
+        raise UnknownContractError(f'unknown message contract {contract_name(contract)}')
+    Draft202012Validator.check_schema(schema)
+    # Como no conversor, o format também é validado (date-time)
+    return Draft202012Validator(schema, format_checker=Draft202012Validator.FORMAT_CHECKER)
+
+
+def validate(contract: tuple[str, int], body) -> None:
+    error = best_match(load_validator(contract).iter_errors(body))
+    if error is not None:
+        location = '/' + '/'.join(str(part) for part in error.absolute_path)
+        raise InvalidMessageError(f'invalid {contract_name(contract)} message: {location}: {error.message}')
@@ -1,14 +1,17 @@
 from django.core.management import BaseCommand
-from core.rabbitmq import create_rabbitmq_connection
-from core.services import create_video_service_factory
-from kombu import Exchange, Queue
+from core import contracts
+from core.rabbitmq import conversion_exchange, create_rabbitmq_connection, park_message
+from core.models import Video
+from core.services import VideoMediaInvalidStatusException, create_video_service_factory
+from core.tracing import consume_span
+from kombu import Queue
 
 class Command(BaseCommand):
     help = 'Register processed video path'
 
     def handle(self, *args, **options):
         self.stdout.write(self.style.SUCCESS('Starting consumer....'))
-        exchange = Exchange('conversion_exchange', type='direct', auto_delete=True)
+        exchange = conversion_exchange()
         queue = Queue('finish-conversion', exchange, routing_key='finish-conversion')
 
         with create_rabbitmq_connection() as conn:
@@ -18,6 +21,28 @@ class Command(BaseCommand):
                     conn.drain_events()
     
     def process_message(self, body, message):
+        with consume_span('finish-conversion', message):
+            self.handle_message(body, message)
+
+    def handle_message(self, body, message):
         self.stdout.write(self.style.SUCCESS(f'Processing message: {body}'))
-        create_video_service_factory().register_processed_video_path(body['video_id'], body['path'])
+        # Mensagens de outro contrato ou fora do schema ficam na parking queue
+        contract = contracts.contract_from_headers(message.headers, contracts.CONVERSION_COMPLETED_V1)
+        try:
+            if contract != contracts.CONVERSION_COMPLETED_V1:
+                raise contracts.UnknownContractError(f'unexpected message contract {contracts.contract_name(contract)}')
+            contracts.validate(contract, body)
+        except contracts.ContractError as e:
+            self.stdout.write(self.style.ERROR(f'Parking message: {e}'))
+            park_message(message, 'finish-conversion', str(e))
+            message.ack()
+            return
+        try:
+            registered = create_video_service_factory().register_processed_video_path(body['video_id'], body['dash_manifest'], body['hls_manifest'])
+            if not registered:
+                self.stdout.write(self.style.WARNING(f'Duplicate confirmation for video {body["video_id"]}, ignoring'))
+        except (Video.DoesNotExist, Video.video_media.RelatedObjectDoesNotExist, VideoMediaInvalidStatusException) as e:
+            # Sem o ack a mensagem voltaria para a fila e derrubaria o consumidor de novo
+            self.stdout.write(self.style.ERROR(f'Parking message: {e}'))
+            park_message(message, 'finish-conversion', str(e))
         message.ack()
\ No newline at end of file
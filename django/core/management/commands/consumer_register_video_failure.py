@@ -0,0 +1,47 @@
+from django.core.management import BaseCommand
+from core import contracts
+from core.models import Video
+from core.rabbitmq import conversion_exchange, create_rabbitmq_connection, park_message
+from core.services import create_video_service_factory
+from core.tracing import consume_span
+from kombu import Queue
+
+class Command(BaseCommand):
+    help = 'Register video conversion failures'
+
+    def handle(self, *args, **options):
+        self.stdout.write(self.style.SUCCESS('Starting consumer....'))
+        exchange = conversion_exchange()
+        queue = Queue('conversion-failed', exchange, routing_key='conversion-failed')
+
+        with create_rabbitmq_connection() as conn:
+            with conn.Consumer(queue, callbacks=[self.process_message]):
+                while True:
+                    self.stdout.write(self.style.SUCCESS('Waiting for messages....'))
+                    conn.drain_events()
+    
+    def process_message(self, body, message):
+        with consume_span('conversion-failed', message):
+            self.handle_message(body, message)
+
+    def handle_message(self, body, message):
+        self.stdout.write(self.style.SUCCESS(f'Processing message: {body}'))
+        # Mensagens de outro contrato ou fora do schema ficam na parking queue
+        contract = contracts.contract_from_headers(message.headers, contracts.CONVERSION_FAILED_V1)
+        try:
+            if contract != contracts.CONVERSION_FAILED_V1:
+                raise contracts.UnknownContractError(f'unexpected message contract {contracts.contract_name(contract)}')
+            contracts.validate(contract, body)
+        except contracts.ContractError as e:
+            self.stdout.write(self.style.ERROR(f'Parking message: {e}'))
+            park_message(message, 'conversion-failed', str(e))
+            message.ack()
+            return
+        # Enquanto o conversor tenta de novo o vídeo continua em processamento
+        if not body['will_retry']:
+            try:
+                create_video_service_factory().register_processing_error(body['video_id'])
+            except (Video.DoesNotExist, Video.video_media.RelatedObjectDoesNotExist) as e:
+                self.stdout.write(self.style.ERROR(f'Parking message: {e}'))
+                park_message(message, 'conversion-failed', str(e))
+        message.ack()
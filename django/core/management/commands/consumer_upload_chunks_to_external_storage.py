@@ -1,14 +1,15 @@
 from django.core.management import BaseCommand
-from core.rabbitmq import create_rabbitmq_connection
+from core.rabbitmq import conversion_exchange, create_rabbitmq_connection
 from core.services import create_video_service_factory
-from kombu import Exchange, Queue
+from core.tracing import consume_span
+from kombu import Queue
 
 class Command(BaseCommand):
     help = 'Upload chunks to external storage'
 
     def handle(self, *args, **options):
         self.stdout.write(self.style.SUCCESS('Starting consumer....'))
-        exchange = Exchange('conversion_exchange', type='direct', auto_delete=True)
+        exchange = conversion_exchange()
         queue = Queue('chunks', exchange, routing_key='chunks')
 
         with create_rabbitmq_connection() as conn:
@@ -18,6 +19,10 @@ class Command(BaseCommand):
                     conn.drain_events()
     
     def process_message(self, body, message):
+        with consume_span('chunks', message):
+            self.handle_message(body, message)
+
+    def handle_message(self, body, message):
         self.stdout.write(self.style.SUCCESS(f'Processing message: {body}'))
         create_video_service_factory().upload_chunks_to_external_storage(body['video_id'])
         message.ack()
\ No newline at end of file
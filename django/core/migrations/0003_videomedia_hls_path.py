@@ -0,0 +1,16 @@
+from django.db import migrations, models
+
+
+class Migration(migrations.Migration):
+
+    dependencies = [
+        ('core', '0002_video_author'),
+    ]
+
+    operations = [
+        migrations.AddField(
+            model_name='videomedia',
+            name='hls_path',
+            field=models.CharField(blank=True, default='', max_length=255, verbose_name='Vídeo HLS'),
+        ),
+    ]
@@ -68,6 +68,7 @@ class VideoMedia(models.Model):
         PROCESS_ERROR = 'PROCESSING_ERROR', 'Erro no Processamento'
 
     video_path = models.CharField(max_length=255, verbose_name='Vídeo')
+    hls_path = models.CharField(max_length=255, blank=True, default='', verbose_name='Vídeo HLS')
     status = models.CharField(max_length=20, choices=Status.choices, default=Status.UPLOADED_STARTED, verbose_name='Status')
     video = models.OneToOneField('Video', on_delete=models.PROTECT, verbose_name='Vídeo', related_name='video_media')
 
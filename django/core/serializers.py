@@ -13,6 +13,7 @@ class VideoSerializer(serializers.ModelSerializer):
     tags = serializers.PrimaryKeyRelatedField(many=True, read_only=True)
     thumbnail = serializers.SerializerMethodField()
     video_url = serializers.SerializerMethodField()
+    hls_url = serializers.SerializerMethodField()
 
 
     def get_thumbnail(self, obj):
@@ -22,8 +23,14 @@ class VideoSerializer(serializers.ModelSerializer):
     def get_video_url(self, obj):
         assets_url = settings.ASSETS_URL
         return f'{assets_url}{obj.video_media.video_path}'
+
+    def get_hls_url(self, obj):
+        if not obj.video_media.hls_path:
+            return None
+        assets_url = settings.ASSETS_URL
+        return f'{assets_url}{obj.video_media.hls_path}'
     
     class Meta:
         model = Video
-        fields = ['id','title', 'description', 'slug', 'published_at', 'views', 'likes', 'tags', 'thumbnail', 'video_url']
+        fields = ['id','title', 'description', 'slug', 'published_at', 'views', 'likes', 'tags', 'thumbnail', 'video_url', 'hls_url']
     
\ No newline at end of file
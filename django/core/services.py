@@ -3,7 +3,9 @@ import os
 import shutil
 from django.db import IntegrityError, transaction
 from core.models import Video, VideoMedia
+from core import contracts
 from core.rabbitmq import create_rabbitmq_connection
+from core.tracing import publish_span
 
 @dataclass
 class VideoService:
@@ -96,28 +98,55 @@ class VideoService:
         source_path = self.get_chunk_directory(video_id)
         dest_path = f'/media/uploads/{video_id}'
         self.storage.move_chunks(source_path, dest_path)
-        self.__produce_message(video_id, dest_path, 'conversion')
+        self.__produce_message(video_id, dest_path, 'conversion', contracts.CONVERSION_REQUESTED_V1)
 
 
-    def register_processed_video_path(self, video_id: int, video_path) -> None:
+    def register_processed_video_path(self, video_id: int, dash_manifest: str, hls_manifest: str) -> bool:
         video = self.find_video(video_id)
         video_media = video.video_media
-        if video_media.status != VideoMedia.Status.PROCESS_STARTED:
+        # Um reprocessamento forçado conclui de novo um vídeo já processado ou que falhou, atualizando os manifestos
+        if video_media.status not in (VideoMedia.Status.PROCESS_STARTED, VideoMedia.Status.PROCESS_FINISHED, VideoMedia.Status.PROCESS_ERROR):
             raise VideoMediaInvalidStatusException('Processing must be started to finish it.')
-        video_media.video_path = video_path.replace('/media/uploads/', '') + '/mpeg-dash/output.mpd'
+        video_path = dash_manifest.replace('/media/uploads/', '')
+        hls_path = hls_manifest.replace('/media/uploads/', '')
+        # O outbox entrega cada confirmação ao menos uma vez, uma duplicata não muda nada
+        if (video_media.status == VideoMedia.Status.PROCESS_FINISHED
+                and video_media.video_path == video_path and video_media.hls_path == hls_path):
+            return False
+        video_media.video_path = video_path
+        video_media.hls_path = hls_path
         video_media.status = VideoMedia.Status.PROCESS_FINISHED
         video_media.save()
+        return True
+
+    def register_processing_error(self, video_id: int) -> bool:
+        video = self.find_video(video_id)
+        video_media = video.video_media
+        # Só um vídeo em processamento passa para erro: uma falha repetida ou de um reprocessamento
+        # forçado, que mantém a saída anterior, não muda nada
+        if video_media.status != VideoMedia.Status.PROCESS_STARTED:
+            return False
+        video_media.status = VideoMedia.Status.PROCESS_ERROR
+        video_media.save()
+        return True
     
-    def __produce_message(self, video_id: int, path: str, routing_key: str):
-        with create_rabbitmq_connection() as conn:
+    def __produce_message(self, video_id: int, path: str, routing_key: str, contract: tuple[str, int] | None = None):
+        message = {
+            'video_id': video_id,
+            'path': path
+        }
+        # Mensagens para o conversor levam o contrato, validadas com os schemas de golang/pkg/contracts/schemas
+        headers = {}
+        if contract:
+            contracts.validate(contract, message)
+            headers = contracts.contract_headers(contract)
+        with publish_span(routing_key, headers), create_rabbitmq_connection() as conn:
             producer = conn.Producer(serializer='json')
             producer.publish(
-                {
-                'video_id': video_id,
-                'path': path
-                }, 
+                message,
                 exchange='conversion_exchange',
-                routing_key=routing_key
+                routing_key=routing_key,
+                headers=headers
             )	
 
 def create_video_service_factory() -> VideoService:
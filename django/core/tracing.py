@@ -0,0 +1,36 @@
+import os
+from contextlib import contextmanager
+
+from opentelemetry import propagate, trace
+from opentelemetry.sdk.resources import Resource
+from opentelemetry.sdk.trace import TracerProvider
+from opentelemetry.sdk.trace.export import BatchSpanProcessor
+
+# Trace context W3C (traceparent) nos headers AMQP, o mesmo formato lido e enviado pelo conversor
+
+tracer = trace.get_tracer('core')
+
+
+def setup_tracing() -> None:
+    # Mesmas variáveis do conversor; sem coletor os spans são descartados, mas o trace context segue nas mensagens
+    provider = TracerProvider(resource=Resource.create({'service.name': os.environ.get('OTEL_SERVICE_NAME', 'django')}))
+    if os.environ.get('OTEL_EXPORTER_OTLP_ENDPOINT') and os.environ.get('OTEL_TRACES_EXPORTER', 'otlp') != 'none':
+        from opentelemetry.exporter.otlp.proto.http.trace_exporter import OTLPSpanExporter
+        provider.add_span_processor(BatchSpanProcessor(OTLPSpanExporter()))
+    trace.set_tracer_provider(provider)
+
+
+@contextmanager
+def publish_span(routing_key: str, headers: dict):
+    # O span da publicação é o pai dos spans de quem consumir a mensagem
+    with tracer.start_as_current_span(f'publish {routing_key}', kind=trace.SpanKind.PRODUCER) as span:
+        propagate.inject(headers)
+        yield span
+
+
+@contextmanager
+def consume_span(queue_name: str, message):
+    # Continua o trace de quem publicou a mensagem
+    context = propagate.extract(message.headers or {})
+    with tracer.start_as_current_span(f'consume {queue_name}', context=context, kind=trace.SpanKind.CONSUMER) as span:
+        yield span
@@ -143,3 +143,6 @@ MEDIA_ROOT = '/media/uploads/'
 
 RABBITMQ_URL = os.environ.get('RABBITMQ_URL')
 ASSETS_URL = os.environ.get('ASSETS_URL')
+
+# Schemas dos contratos das mensagens, compartilhados com o conversor
+CONTRACT_SCHEMAS_DIR = os.environ.get('CONTRACT_SCHEMAS_DIR', BASE_DIR.parent / 'golang' / 'pkg' / 'contracts' / 'schemas')
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"imersaofc/internal/converter"
+)
+
+const (
+	defaultJobsLimit = 100
+	maxJobsLimit     = 1000
+)
+
+// adminServer is the HTTP API used by ops to inspect and steer the conversion jobs:
+//
+//	GET  /jobs?state=failed,cancelled&from=1&to=100&limit=50  lists the jobs
+//	GET  /jobs/{id}                                           job detail with phase timings and errors
+//	POST /jobs/{id}/retry                                     queues a failed or cancelled job again
+//	POST /jobs/{id}/cancel                                    cancels a job, stopping it when running
+//	GET  /workers                                             workers with running jobs
+//
+// Every request must carry the token in an "Authorization: Bearer" header, all of them are rejected
+// when no token is configured.
+type adminServer struct {
+	token       string
+	store       converter.JobStore
+	publish     func(converter.VideoTask) error
+	rootPath    string
+	workerID    string
+	concurrency int
+	startedAt   time.Time
+	now         func() time.Time
+}
+
+// jobDetail is the response of GET /jobs/{id}
+type jobDetail struct {
+	*converter.Job
+	Phases []converter.PhaseTiming `json:"phases"`
+	Errors []converter.ErrorRecord `json:"errors"`
+}
+
+// workerStatus is a worker in the response of GET /workers
+type workerStatus struct {
+	WorkerID    string          `json:"worker_id"`
+	Current     bool            `json:"current"` // The worker serving the request
+	Concurrency int             `json:"concurrency,omitempty"`
+	StartedAt   *time.Time      `json:"started_at,omitempty"`
+	Jobs        []converter.Job `json:"jobs"`
+}
+
+// routes registers the endpoints of the API
+func (s *adminServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /jobs", s.authorize(s.listJobs))
+	mux.HandleFunc("GET /jobs/{id}", s.authorize(s.getJob))
+	mux.HandleFunc("POST /jobs/{id}/retry", s.authorize(s.retryJob))
+	mux.HandleFunc("POST /jobs/{id}/cancel", s.authorize(s.cancelJob))
+	mux.HandleFunc("GET /workers", s.authorize(s.listWorkers))
+	return mux
+}
+
+// authorize rejects the requests without the bearer token of the server
+func (s *adminServer) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if s.token == "" || !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid admin token"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *adminServer) listJobs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := converter.JobFilter{Limit: defaultJobsLimit}
+	for _, param := range []struct {
+		name string
+		dst  *int
+	}{{"from", &filter.FromID}, {"to", &filter.ToID}, {"limit", &filter.Limit}} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil || number <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s %q", param.name, value))
+			return
+		}
+		*param.dst = number
+	}
+	filter.Limit = min(filter.Limit, maxJobsLimit)
+
+	if states := query.Get("state"); states != "" {
+		for _, state := range strings.Split(states, ",") {
+			state := converter.JobState(strings.TrimSpace(state))
+			switch state {
+			case converter.JobQueued, converter.JobRunning, converter.JobSucceeded, converter.JobFailed, converter.JobCancelled:
+				filter.States = append(filter.States, state)
+			default:
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid state %q", state))
+				return
+			}
+		}
+	}
+
+	jobs, err := s.store.FindJobs(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(jobs))
+}
+
+func (s *adminServer) getJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.loadJob(w, r)
+	if !ok {
+		return
+	}
+	errs, err := s.store.ErrorsByVideo(job.VideoID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, jobDetail{Job: job, Phases: nonNil(job.PhaseTimings(s.now())), Errors: nonNil(errs)})
+}
+
+// retryJob queues a failed or cancelled job and publishes a conversion message for it. A queued job is only
+// published again, e.g. when a previous retry could not publish.
+func (s *adminServer) retryJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.loadJob(w, r)
+	if !ok {
+		return
+	}
+	switch job.State {
+	case converter.JobFailed, converter.JobCancelled:
+		if err := s.store.Transition(job.VideoID, converter.JobQueued, "retried by operator"); err != nil {
+			writeTransitionError(w, err)
+			return
+		}
+	case converter.JobQueued:
+	default:
+		writeError(w, http.StatusConflict, fmt.Errorf("cannot retry a %s job", job.State))
+		return
+	}
+
+	task := converter.VideoTask{VideoID: job.VideoID, Path: job.Path}
+	if task.Path == "" {
+		task.Path = filepath.Join(s.rootPath, strconv.Itoa(job.VideoID))
+	}
+	if err := s.publish(task); err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("job queued but the conversion message could not be published: %v", err))
+		return
+	}
+	slog.Info("Job retried by operator", slog.Int("video_id", job.VideoID))
+	s.writeJob(w, job.VideoID)
+}
+
+// cancelJob cancels the job. A running conversion stops at its next lease renewal, which fails once the
+// job is no longer running.
+func (s *adminServer) cancelJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.loadJob(w, r)
+	if !ok {
+		return
+	}
+	if err := s.store.Transition(job.VideoID, converter.JobCancelled, "cancelled by operator"); err != nil {
+		writeTransitionError(w, err)
+		return
+	}
+	slog.Info("Job cancelled by operator", slog.Int("video_id", job.VideoID), slog.String("state", string(job.State)))
+	s.writeJob(w, job.VideoID)
+}
+
+func (s *adminServer) listWorkers(w http.ResponseWriter, r *http.Request) {
+	jobs, err := s.store.FindJobs(converter.JobFilter{States: []converter.JobState{converter.JobRunning}})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// O worker atual aparece mesmo sem conversões em andamento
+	startedAt := s.startedAt
+	workers := []workerStatus{{WorkerID: s.workerID, Current: true, Concurrency: s.concurrency, StartedAt: &startedAt, Jobs: []converter.Job{}}}
+	index := map[string]int{s.workerID: 0}
+	for _, job := range jobs {
+		i, ok := index[job.WorkerID]
+		if !ok {
+			i = len(workers)
+			index[job.WorkerID] = i
+			workers = append(workers, workerStatus{WorkerID: job.WorkerID})
+		}
+		workers[i].Jobs = append(workers[i].Jobs, job)
+	}
+	writeJSON(w, http.StatusOK, workers)
+}
+
+// loadJob loads the job of the {id} path value, writing the error response when it cannot
+func (s *adminServer) loadJob(w http.ResponseWriter, r *http.Request) (*converter.Job, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid video ID %q", r.PathValue("id")))
+		return nil, false
+	}
+	job, err := s.store.GetJob(id)
+	if errors.Is(err, converter.ErrJobNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return job, true
+}
+
+// writeJob responds with the job as it is after a change
+func (s *adminServer) writeJob(w http.ResponseWriter, videoID int) {
+	job, err := s.store.GetJob(videoID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func writeTransitionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, converter.ErrInvalidTransition) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Warn("Failed to write response", slog.String("error", err.Error()))
+	}
+}
+
+// nonNil makes empty lists encode as [] instead of null
+func nonNil[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
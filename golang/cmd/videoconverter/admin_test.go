@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"imersaofc/internal/converter"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type adminFixture struct {
+	store      *converter.SQLStore
+	server     *httptest.Server
+	published  []converter.VideoTask
+	publishErr error
+}
+
+// newAdminFixture serves the admin API on the jobs of reprocessStore
+func newAdminFixture(t *testing.T) *adminFixture {
+	f := &adminFixture{store: reprocessStore(t)}
+	admin := &adminServer{
+		token: "secret",
+		store: f.store,
+		publish: func(task converter.VideoTask) error {
+			if f.publishErr != nil {
+				return f.publishErr
+			}
+			f.published = append(f.published, task)
+			return nil
+		},
+		rootPath:    "/media/uploads",
+		workerID:    "worker-2",
+		concurrency: 2,
+		startedAt:   time.Now(),
+		now:         time.Now,
+	}
+	f.server = httptest.NewServer(admin.routes())
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// do sends the request and decodes the JSON response into body
+func (f *adminFixture) do(t *testing.T, method, path string, body any) int {
+	req, err := http.NewRequest(method, f.server.URL+path, nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	if body != nil {
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(body))
+	}
+	return resp.StatusCode
+}
+
+func jobIDs(jobs []converter.Job) []int {
+	ids := []int{}
+	for _, job := range jobs {
+		ids = append(ids, job.VideoID)
+	}
+	return ids
+}
+
+func TestAdminListJobs(t *testing.T) {
+	f := newAdminFixture(t)
+
+	var jobs []converter.Job
+	assert.Equal(t, http.StatusOK, f.do(t, "GET", "/jobs", &jobs))
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, jobIDs(jobs))
+
+	assert.Equal(t, http.StatusOK, f.do(t, "GET", "/jobs?state=failed,succeeded&from=2&limit=2", &jobs))
+	assert.Equal(t, []int{2, 3}, jobIDs(jobs))
+
+	assert.Equal(t, http.StatusOK, f.do(t, "GET", "/jobs?from=100", &jobs))
+	assert.Empty(t, jobs)
+
+	var resp map[string]string
+	assert.Equal(t, http.StatusBadRequest, f.do(t, "GET", "/jobs?state=done", &resp))
+	assert.Equal(t, `invalid state "done"`, resp["error"])
+	assert.Equal(t, http.StatusBadRequest, f.do(t, "GET", "/jobs?limit=-1", &resp))
+}
+
+func TestAdminGetJob(t *testing.T) {
+	f := newAdminFixture(t)
+	assert.NoError(t, f.store.StartPhase(4, converter.PhaseMerging))
+	assert.NoError(t, f.store.StartPhase(4, converter.PhaseEncoding))
+	assert.NoError(t, f.store.RegisterError(converter.ErrorRecord{VideoID: 4, Phase: converter.PhaseEncoding, Code: converter.CodeEncodeFailed, Attempt: 1, Message: "Error during video conversion"}))
+
+	var detail struct {
+		converter.Job
+		Phases []converter.PhaseTiming `json:"phases"`
+		Errors []converter.ErrorRecord `json:"errors"`
+	}
+	assert.Equal(t, http.StatusOK, f.do(t, "GET", "/jobs/4", &detail))
+	assert.Equal(t, converter.JobRunning, detail.State)
+	assert.Equal(t, converter.PhaseEncoding, detail.Phase)
+	assert.Len(t, detail.Phases, 2)
+	assert.Equal(t, converter.PhaseMerging, detail.Phases[0].Phase)
+	assert.Len(t, detail.Errors, 1)
+	assert.Equal(t, converter.CodeEncodeFailed, detail.Errors[0].Code)
+
+	var resp map[string]string
+	assert.Equal(t, http.StatusNotFound, f.do(t, "GET", "/jobs/999", &resp))
+	assert.Equal(t, http.StatusBadRequest, f.do(t, "GET", "/jobs/abc", &resp))
+}
+
+func TestAdminRetryJob(t *testing.T) {
+	f := newAdminFixture(t)
+
+	var job converter.Job
+	assert.Equal(t, http.StatusOK, f.do(t, "POST", "/jobs/1/retry", &job))
+	assert.Equal(t, converter.JobQueued, job.State)
+	assert.Equal(t, []converter.VideoTask{{VideoID: 1, Path: "/media/uploads/1"}}, f.published)
+
+	// Sem conseguir publicar o job fica na fila, e pode ser publicado de novo
+	f.publishErr = errors.New("channel closed")
+	var resp map[string]string
+	assert.Equal(t, http.StatusBadGateway, f.do(t, "POST", "/jobs/3/retry", &resp))
+	f.publishErr = nil
+	assert.Equal(t, http.StatusOK, f.do(t, "POST", "/jobs/3/retry", &job))
+	assert.Equal(t, converter.JobQueued, job.State)
+	assert.Len(t, f.published, 2)
+
+	// Only stopped jobs are retried
+	for _, id := range []int{2, 4} {
+		assert.Equal(t, http.StatusConflict, f.do(t, "POST", fmt.Sprintf("/jobs/%d/retry", id), &resp))
+	}
+	assert.Equal(t, http.StatusNotFound, f.do(t, "POST", "/jobs/999/retry", &resp))
+}
+
+func TestAdminCancelJob(t *testing.T) {
+	f := newAdminFixture(t)
+
+	// Cancelar um job em execução faz o worker perder o lease
+	var job converter.Job
+	assert.Equal(t, http.StatusOK, f.do(t, "POST", "/jobs/4/cancel", &job))
+	assert.Equal(t, converter.JobCancelled, job.State)
+	assert.ErrorIs(t, f.store.RenewLease(4, "worker-1", time.Minute), converter.ErrLeaseLost)
+
+	var resp map[string]string
+	assert.Equal(t, http.StatusConflict, f.do(t, "POST", "/jobs/2/cancel", &resp))
+	assert.Contains(t, resp["error"], "invalid job state transition")
+}
+
+func TestAdminListWorkers(t *testing.T) {
+	f := newAdminFixture(t)
+
+	var workers []workerStatus
+	assert.Equal(t, http.StatusOK, f.do(t, "GET", "/workers", &workers))
+	assert.Len(t, workers, 2)
+	assert.Equal(t, "worker-2", workers[0].WorkerID)
+	assert.True(t, workers[0].Current)
+	assert.Equal(t, 2, workers[0].Concurrency)
+	assert.Empty(t, workers[0].Jobs)
+	assert.Equal(t, "worker-1", workers[1].WorkerID)
+	assert.False(t, workers[1].Current)
+	assert.Equal(t, []int{4}, jobIDs(workers[1].Jobs))
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	f := newAdminFixture(t)
+	for _, header := range []string{"", "Bearer wrong", "secret", "Basic c2VjcmV0"} {
+		req, err := http.NewRequest(http.MethodPost, f.server.URL+"/jobs/1/retry", nil)
+		assert.NoError(t, err)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, header)
+	}
+	assert.Empty(t, f.published)
+
+	// Sem token configurado a API recusa tudo
+	admin := &adminServer{store: f.store}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/workers", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	admin.routes().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
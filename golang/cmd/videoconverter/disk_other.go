@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// freeDiskSpace is not available where statfs is not, the disk check is skipped
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// healthCheck probes a dependency, returning why it is unhealthy
+type healthCheck struct {
+	name     string
+	liveness bool // Also fails /healthz, for failures only a restart recovers from
+	check    func(ctx context.Context) error
+}
+
+// healthServer serves the probes of the orchestrator:
+//
+//	GET /healthz  liveness, fails when the consumer is gone
+//	GET /readyz   readiness, fails when any check fails or the worker is draining
+type healthServer struct {
+	checks   []healthCheck
+	timeout  time.Duration // Shared by the checks of a request
+	draining atomic.Bool
+}
+
+// healthReport is the response of the probes, with "ok" or the error of each check
+type healthReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// register adds the probes to the mux
+func (h *healthServer) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, true)
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, false)
+	})
+}
+
+// drain makes the worker report itself as not ready, so no new work is routed to it while it shuts down
+func (h *healthServer) drain() {
+	h.draining.Store(true)
+}
+
+func (h *healthServer) serve(w http.ResponseWriter, r *http.Request, liveness bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	report := healthReport{Status: "ok", Checks: map[string]string{}}
+	for _, check := range h.checks {
+		if liveness && !check.liveness {
+			continue
+		}
+		if err := check.check(ctx); err != nil {
+			report.Status = "unavailable"
+			report.Checks[check.name] = err.Error()
+			continue
+		}
+		report.Checks[check.name] = "ok"
+	}
+	if !liveness && h.draining.Load() {
+		report.Status = "unavailable"
+		report.Checks["draining"] = "shutting down"
+	}
+
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+// consumerCheck fails once done is closed, i.e. every consumer goroutine has exited
+func consumerCheck(done <-chan struct{}) healthCheck {
+	return healthCheck{name: "consumer", liveness: true, check: func(ctx context.Context) error {
+		select {
+		case <-done:
+			return errors.New("consumer stopped")
+		default:
+			return nil
+		}
+	}}
+}
+
+// diskCheck fails when less than minFree bytes are available under path
+func diskCheck(path string, minFree uint64) healthCheck {
+	return healthCheck{name: "disk", check: func(ctx context.Context) error {
+		free, err := freeDiskSpace(path)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if free < minFree {
+			return fmt.Errorf("%d MB free under %s, below %d MB", free>>20, path, minFree>>20)
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// probe calls the endpoint and decodes the report
+func probe(t *testing.T, h *healthServer, path string) (int, healthReport) {
+	mux := http.NewServeMux()
+	h.register(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+
+	var report healthReport
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	return rec.Code, report
+}
+
+func TestHealthServer(t *testing.T) {
+	var dbErr error
+	consumerDone := make(chan struct{})
+	h := &healthServer{timeout: time.Second, checks: []healthCheck{
+		{name: "database", check: func(ctx context.Context) error { return dbErr }},
+		consumerCheck(consumerDone),
+	}}
+
+	code, report := probe(t, h, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, healthReport{Status: "ok", Checks: map[string]string{"database": "ok", "consumer": "ok"}}, report)
+
+	// O banco fora do ar tira o worker do balanceamento, mas não o reinicia
+	dbErr = errors.New("connection refused")
+	code, report = probe(t, h, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "connection refused", report.Checks["database"])
+	code, report = probe(t, h, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{"consumer": "ok"}, report.Checks)
+
+	// Without a consumer only a restart helps
+	dbErr = nil
+	close(consumerDone)
+	code, report = probe(t, h, "/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "consumer stopped", report.Checks["consumer"])
+}
+
+func TestHealthServerDraining(t *testing.T) {
+	h := &healthServer{timeout: time.Second}
+	h.drain()
+
+	code, report := probe(t, h, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "shutting down", report.Checks["draining"])
+
+	code, _ = probe(t, h, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestDiskCheck(t *testing.T) {
+	ctx := context.Background()
+	assert.NoError(t, diskCheck(t.TempDir(), 1).check(ctx))
+	assert.ErrorContains(t, diskCheck(t.TempDir(), math.MaxUint64).check(ctx), "MB free under")
+	assert.Error(t, diskCheck("/does/not/exist", 1).check(ctx))
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newQueueLagGauge reports the messages waiting in the queue, read from the broker on each scrape
+func newQueueLagGauge(queueName string, depth func(queueName string) (int, error)) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   "videoconverter",
+		Name:        "queue_lag_messages",
+		Help:        "Conversion messages waiting in the queue.",
+		ConstLabels: prometheus.Labels{"queue": queueName},
+	}, func() float64 {
+		messages, err := depth(queueName)
+		if err != nil {
+			slog.Warn("Failed to read queue depth", slog.String("queue", queueName), slog.String("error", err.Error()))
+			return math.NaN()
+		}
+		return float64(messages)
+	})
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueLagGauge(t *testing.T) {
+	var depthErr error
+	gauge := newQueueLagGauge("video_conversion_queue", func(queueName string) (int, error) {
+		assert.Equal(t, "video_conversion_queue", queueName)
+		return 7, depthErr
+	})
+	assert.Equal(t, float64(7), testutil.ToFloat64(gauge))
+
+	// Sem resposta do broker o valor fica indefinido em vez de zero
+	depthErr = errors.New("channel closed")
+	assert.True(t, math.IsNaN(testutil.ToFloat64(gauge)))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"imersaofc/internal/converter"
+)
+
+// runMigrate implements the migrate subcommand:
+//
+//	videoconverter migrate up           applies the pending migrations
+//	videoconverter migrate down [steps] reverts the last steps migrations, 1 by default
+//	videoconverter migrate status       lists the migrations and when they were applied
+func runMigrate(ctx context.Context, store *converter.SQLStore, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: videoconverter migrate up|down [steps]|status")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := store.MigrateUp(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d migrations applied\n", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+			steps = n
+		}
+		reverted, err := store.MigrateDown(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d migrations reverted\n", reverted)
+	case "status":
+		status, err := store.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, migration := range status {
+			appliedAt := "pending"
+			if migration.AppliedAt != nil {
+				appliedAt = migration.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", migration.Version, migration.Name, appliedAt)
+		}
+		w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down or status", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"imersaofc/internal/converter"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunMigrate(t *testing.T) {
+	ctx := context.Background()
+	store, err := converter.OpenSQLiteStore(":memory:")
+	assert.NoError(t, err)
+	defer store.Close()
+
+	// O store é aberto sem migrations, como no PostgreSQL
+	var out bytes.Buffer
+	assert.NoError(t, runMigrate(ctx, store, []string{"up"}, &out))
+	assert.Regexp(t, `^\d+ migrations applied\n$`, out.String())
+
+	out.Reset()
+	assert.NoError(t, runMigrate(ctx, store, []string{"down", "1"}, &out))
+	assert.Equal(t, "1 migrations reverted\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, runMigrate(ctx, store, []string{"status"}, &out))
+	assert.Regexp(t, `\n0001\s+create_processed_videos\s+\d{4}-\d{2}-\d{2}T`, out.String())
+	assert.Equal(t, 1, strings.Count(out.String(), "pending"))
+	assert.Regexp(t, `\s+pending\n$`, out.String())
+
+	out.Reset()
+	assert.NoError(t, runMigrate(ctx, store, []string{"up"}, &out))
+	assert.Equal(t, "1 migrations applied\n", out.String())
+
+	assert.Error(t, runMigrate(ctx, store, nil, &out))
+	assert.Error(t, runMigrate(ctx, store, []string{"down", "zero"}, &out))
+	assert.Error(t, runMigrate(ctx, store, []string{"redo"}, &out))
+}
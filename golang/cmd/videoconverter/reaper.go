@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// runReaper calls reap every interval until ctx is canceled. Every converter runs one: requeuing
+// expired jobs is atomic, so a job is only handed back once whichever reaper finds it first.
+func runReaper(ctx context.Context, interval time.Duration, reap func(now time.Time)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			reap(now)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunReaper(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var runs int32
+
+	done := make(chan struct{})
+	go func() {
+		runReaper(ctx, 10*time.Millisecond, func(now time.Time) { atomic.AddInt32(&runs, 1) })
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&runs) >= 3 }, time.Second, 5*time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reaper did not stop after cancel")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+
+	"imersaofc/internal/converter"
+)
+
+// runReprocess implements the reprocess subcommand, publishing a forced conversion message for each selected video:
+//
+//	videoconverter reprocess -id 42             a single video
+//	videoconverter reprocess -from 10 -to 20    a range of video IDs
+//	videoconverter reprocess -failed            every failed video, optionally within -from/-to
+//
+// The worker archives the previous output of each video before converting it again. Running jobs are skipped
+// and -dry-run only lists the videos.
+func runReprocess(store converter.JobStore, publish func(converter.VideoTask) error, rootPath string, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("reprocess", flag.ContinueOnError)
+	flags.SetOutput(out)
+	id := flags.Int("id", 0, "reprocess the video with this ID")
+	from := flags.Int("from", 0, "first video ID of the range")
+	to := flags.Int("to", 0, "last video ID of the range")
+	failed := flags.Bool("failed", false, "reprocess the failed videos")
+	dryRun := flags.Bool("dry-run", false, "list the videos without publishing")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var filter converter.JobFilter
+	switch {
+	case *id > 0 && (*from > 0 || *to > 0 || *failed):
+		return fmt.Errorf("-id cannot be combined with -from, -to or -failed")
+	case *id > 0:
+		filter = converter.JobFilter{FromID: *id, ToID: *id}
+	case *from > 0 || *to > 0 || *failed:
+		if *to > 0 && *from > *to {
+			return fmt.Errorf("invalid range %d-%d", *from, *to)
+		}
+		filter = converter.JobFilter{FromID: *from, ToID: *to}
+		if *failed {
+			filter.States = []converter.JobState{converter.JobFailed}
+		}
+	default:
+		return fmt.Errorf("usage: videoconverter reprocess -id ID | -from ID -to ID | -failed [-dry-run]")
+	}
+
+	jobs, err := store.FindJobs(filter)
+	if err != nil {
+		return fmt.Errorf("failed to list jobs: %v", err)
+	}
+	// Um vídeo sem job nunca chegou ao worker, o caminho segue o padrão do Django
+	if *id > 0 && len(jobs) == 0 {
+		jobs = []converter.Job{{VideoID: *id}}
+	}
+
+	published, skipped := 0, 0
+	for _, job := range jobs {
+		if job.State == converter.JobRunning {
+			fmt.Fprintf(out, "skipping video %d: conversion running on %s\n", job.VideoID, job.WorkerID)
+			skipped++
+			continue
+		}
+
+		task := converter.VideoTask{VideoID: job.VideoID, Path: job.Path, Force: true}
+		if task.Path == "" {
+			task.Path = filepath.Join(rootPath, strconv.Itoa(job.VideoID))
+		}
+		if *dryRun {
+			fmt.Fprintf(out, "would reprocess video %d (%s)\n", task.VideoID, task.Path)
+			continue
+		}
+		if err := publish(task); err != nil {
+			return fmt.Errorf("failed to publish video %d: %v", task.VideoID, err)
+		}
+		fmt.Fprintf(out, "reprocessing video %d (%s)\n", task.VideoID, task.Path)
+		published++
+	}
+
+	fmt.Fprintf(out, "%d videos published, %d skipped\n", published, skipped)
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"imersaofc/internal/converter"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// reprocessStore creates jobs 1 to 5: 1 and 3 failed, 2 succeeded, 4 running and 5 without a path
+func reprocessStore(t *testing.T) *converter.SQLStore {
+	store, err := converter.OpenSQLiteStore(":memory:")
+	assert.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	_, err = store.MigrateUp(context.Background())
+	assert.NoError(t, err)
+
+	for id := 1; id <= 5; id++ {
+		path := fmt.Sprintf("/media/uploads/%d", id)
+		if id == 5 {
+			path = ""
+		}
+		_, _, err := store.ClaimJob(converter.VideoTask{VideoID: id, Path: path}, "worker-1", time.Minute)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, store.Transition(1, converter.JobFailed, "exit status 1"))
+	assert.NoError(t, store.Transition(3, converter.JobFailed, "exit status 1"))
+	assert.NoError(t, store.Transition(5, converter.JobFailed, "exit status 1"))
+	assert.NoError(t, store.MarkProcessed(2, "worker-1"))
+	return store
+}
+
+func TestRunReprocess(t *testing.T) {
+	store := reprocessStore(t)
+	var published []converter.VideoTask
+	publish := func(task converter.VideoTask) error {
+		published = append(published, task)
+		return nil
+	}
+	run := func(args ...string) (string, error) {
+		published = nil
+		var out bytes.Buffer
+		err := runReprocess(store, publish, "/media/uploads", args, &out)
+		return out.String(), err
+	}
+
+	// Todos os vídeos com falha, o caminho ausente segue o padrão do Django
+	_, err := run("-failed")
+	assert.NoError(t, err)
+	assert.Equal(t, []converter.VideoTask{
+		{VideoID: 1, Path: "/media/uploads/1", Force: true},
+		{VideoID: 3, Path: "/media/uploads/3", Force: true},
+		{VideoID: 5, Path: "/media/uploads/5", Force: true},
+	}, published)
+
+	// Um intervalo pula a conversão em andamento
+	out, err := run("-from", "2", "-to", "4")
+	assert.NoError(t, err)
+	assert.Equal(t, []converter.VideoTask{
+		{VideoID: 2, Path: "/media/uploads/2", Force: true},
+		{VideoID: 3, Path: "/media/uploads/3", Force: true},
+	}, published)
+	assert.Contains(t, out, "skipping video 4: conversion running on worker-1")
+	assert.Contains(t, out, "2 videos published, 1 skipped")
+
+	_, err = run("-id", "2")
+	assert.NoError(t, err)
+	assert.Equal(t, []converter.VideoTask{{VideoID: 2, Path: "/media/uploads/2", Force: true}}, published)
+
+	// A video the worker never saw is published too
+	_, err = run("-id", "42")
+	assert.NoError(t, err)
+	assert.Equal(t, []converter.VideoTask{{VideoID: 42, Path: "/media/uploads/42", Force: true}}, published)
+
+	out, err = run("-failed", "-dry-run")
+	assert.NoError(t, err)
+	assert.Empty(t, published)
+	assert.Contains(t, out, "would reprocess video 1 (/media/uploads/1)")
+}
+
+func TestRunReprocessErrors(t *testing.T) {
+	store := reprocessStore(t)
+	var out bytes.Buffer
+	publish := func(task converter.VideoTask) error { return errors.New("channel closed") }
+
+	assert.Error(t, runReprocess(store, publish, "/media/uploads", nil, &out))
+	assert.Error(t, runReprocess(store, publish, "/media/uploads", []string{"-id", "1", "-failed"}, &out))
+	assert.Error(t, runReprocess(store, publish, "/media/uploads", []string{"-from", "5", "-to", "1"}, &out))
+	assert.Error(t, runReprocess(store, publish, "/media/uploads", []string{"-all"}, &out))
+	assert.ErrorContains(t, runReprocess(store, publish, "/media/uploads", []string{"-id", "1"}, &out), "channel closed")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// newSpanExporter creates the exporter selected by OTEL_TRACES_EXPORTER:
+//
+//	otlp  sends the spans over OTLP/HTTP to OTEL_EXPORTER_OTLP_ENDPOINT (or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT)
+//	none  returns no exporter, the spans are dropped but the trace context is still propagated to the messages
+func newSpanExporter(ctx context.Context, kind string) (sdktrace.SpanExporter, error) {
+	switch kind {
+	case "otlp":
+		return otlptracehttp.New(ctx)
+	case "none":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown traces exporter %q, expected otlp or none", kind)
+}
+
+// setupTracing installs the global tracer provider and the W3C trace context propagator.
+// Spans are exported over OTLP when an endpoint is configured, and dropped otherwise.
+// The returned function flushes the pending spans.
+func setupTracing(ctx context.Context, workerID string) (func(context.Context) error, error) {
+	kind := "none"
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		kind = "otlp"
+	}
+	exporter, err := newSpanExporter(ctx, getEnvOrDefault("OTEL_TRACES_EXPORTER", kind))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", getEnvOrDefault("OTEL_SERVICE_NAME", "videoconverter")),
+		attribute.String("service.instance.id", workerID),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %v", err)
+	}
+
+	options := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	if exporter != nil {
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+)
+
+func TestNewSpanExporter(t *testing.T) {
+	ctx := context.Background()
+	exporter, err := newSpanExporter(ctx, "none")
+	assert.NoError(t, err)
+	assert.Nil(t, exporter)
+
+	// O exportador OTLP só conecta ao enviar os spans
+	exporter, err = newSpanExporter(ctx, "otlp")
+	assert.NoError(t, err)
+	assert.NotNil(t, exporter)
+
+	_, err = newSpanExporter(ctx, "zipkin")
+	assert.Error(t, err)
+}
+
+func TestSetupTracing(t *testing.T) {
+	t.Setenv("OTEL_TRACES_EXPORTER", "none")
+	shutdown, err := setupTracing(context.Background(), "worker-1")
+	assert.NoError(t, err)
+	defer shutdown(context.Background())
+
+	// Propagated in the AMQP headers
+	assert.Contains(t, otel.GetTextMapPropagator().Fields(), "traceparent")
+
+	// Sem exportador os spans continuam válidos para o trace context
+	_, span := otel.Tracer("test").Start(context.Background(), "publish")
+	defer span.End()
+	assert.True(t, span.SpanContext().IsValid())
+}
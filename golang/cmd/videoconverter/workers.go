@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// startWorkers handles the deliveries with a fixed number of goroutines, so at most
+// concurrency conversions run at once. The workers stop when ctx is canceled or msgs is closed;
+// the returned WaitGroup is done once every running conversion has finished.
+func startWorkers(ctx context.Context, msgs <-chan amqp.Delivery, concurrency int, handle func(context.Context, amqp.Delivery)) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case d, ok := <-msgs:
+					if !ok {
+						return
+					}
+					handle(ctx, d)
+				}
+			}
+		}()
+	}
+	return &wg
+}
+
+// waitDrain waits for the workers to finish their running conversions, giving up after timeout
+// or on a second shutdown signal. It reports whether the workers finished.
+func waitDrain(done <-chan struct{}, timeout time.Duration, signals <-chan os.Signal) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+	case <-signals:
+	}
+	return false
+}
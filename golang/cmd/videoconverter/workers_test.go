@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartWorkersLimitsConcurrency(t *testing.T) {
+	msgs := make(chan amqp.Delivery)
+	var running, peak, handled int32
+
+	wg := startWorkers(context.Background(), msgs, 3, func(ctx context.Context, d amqp.Delivery) {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if current <= old || atomic.CompareAndSwapInt32(&peak, old, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&handled, 1)
+	})
+
+	for i := 0; i < 10; i++ {
+		msgs <- amqp.Delivery{}
+	}
+	close(msgs)
+	wg.Wait()
+
+	assert.Equal(t, int32(10), handled)
+	assert.Equal(t, int32(3), peak)
+}
+
+func TestStartWorkersStopOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := startWorkers(ctx, make(chan amqp.Delivery), 2, func(ctx context.Context, d amqp.Delivery) {})
+
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("workers did not stop after cancel")
+	}
+}
+
+func TestWaitDrain(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+	assert.True(t, waitDrain(done, time.Minute, nil))
+
+	// Conversões que não terminam a tempo são canceladas
+	assert.False(t, waitDrain(make(chan struct{}), 10*time.Millisecond, nil))
+
+	// Um segundo sinal encerra sem esperar
+	signals := make(chan os.Signal, 1)
+	signals <- syscall.SIGTERM
+	assert.False(t, waitDrain(make(chan struct{}), time.Minute, signals))
+}
@@ -0,0 +1,112 @@
+package converter
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// ErrorRecord is a failed conversion attempt stored in process_errors_log
+type ErrorRecord struct {
+	ID        int64     `json:"id,omitempty"`
+	VideoID   int       `json:"video_id"`
+	Phase     Phase     `json:"phase"`
+	Code      ErrorCode `json:"code"`
+	Attempt   int       `json:"attempt"`
+	Retryable bool      `json:"retryable"`
+	Message   string    `json:"error"`
+	Detail    string    `json:"details"`
+	Stderr    string    `json:"stderr,omitempty"` // Last lines of the ffmpeg output, when ffmpeg failed
+	CreatedAt time.Time `json:"time"`
+}
+
+// newErrorRecord describes the failure of an attempt to convert the video
+func newErrorRecord(videoID, attempt int, message string, err error) ErrorRecord {
+	phase, code := classify(err)
+	return ErrorRecord{
+		VideoID:   videoID,
+		Phase:     phase,
+		Code:      code,
+		Attempt:   attempt,
+		Retryable: IsRetryable(err),
+		Message:   message,
+		Detail:    err.Error(),
+		Stderr:    stderrTail(err),
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+const errorColumns = `id, COALESCE(video_id, 0), COALESCE(phase, ''), code, attempt, retryable,
+	COALESCE(message, ''), COALESCE(detail, ''), COALESCE(stderr, ''), created_at`
+
+// RegisterError stores a failed attempt in the error log. The record is also kept as JSON in error_details,
+// the format used before the columns existed.
+func (s *SQLStore) RegisterError(record ErrorRecord) error {
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = s.now()
+	}
+	serializedError, _ := json.Marshal(record)
+
+	query := `INSERT INTO process_errors_log
+		(video_id, phase, code, attempt, retryable, message, detail, stderr, error_details, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	_, err := s.db.Exec(query,
+		sql.NullInt64{Int64: int64(record.VideoID), Valid: record.VideoID != 0},
+		sql.NullString{String: string(record.Phase), Valid: record.Phase != ""},
+		string(record.Code), record.Attempt, record.Retryable, record.Message, record.Detail,
+		sql.NullString{String: record.Stderr, Valid: record.Stderr != ""},
+		string(serializedError), record.CreatedAt.UTC())
+	return err
+}
+
+// ErrorsByVideo lists the errors of the video, oldest first, giving the history of its attempts
+func (s *SQLStore) ErrorsByVideo(videoID int) ([]ErrorRecord, error) {
+	return s.queryErrors("SELECT "+errorColumns+" FROM process_errors_log WHERE video_id = $1 ORDER BY created_at, id", videoID)
+}
+
+// ErrorsByCode lists the latest errors with the code since the given time, newest first, at most limit
+func (s *SQLStore) ErrorsByCode(code ErrorCode, since time.Time, limit int) ([]ErrorRecord, error) {
+	return s.queryErrors("SELECT "+errorColumns+` FROM process_errors_log
+		WHERE code = $1 AND created_at >= $2 ORDER BY created_at DESC, id DESC LIMIT $3`, string(code), since.UTC(), limit)
+}
+
+// CountErrorsByCode counts the errors of each code since the given time
+func (s *SQLStore) CountErrorsByCode(since time.Time) (map[ErrorCode]int, error) {
+	rows, err := s.db.Query("SELECT code, COUNT(*) FROM process_errors_log WHERE created_at >= $1 GROUP BY code", since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[ErrorCode]int{}
+	for rows.Next() {
+		var code ErrorCode
+		var count int
+		if err := rows.Scan(&code, &count); err != nil {
+			return nil, err
+		}
+		counts[code] = count
+	}
+	return counts, rows.Err()
+}
+
+// queryErrors runs a query selecting errorColumns
+func (s *SQLStore) queryErrors(query string, args ...any) ([]ErrorRecord, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []ErrorRecord
+	for rows.Next() {
+		var record ErrorRecord
+		err := rows.Scan(&record.ID, &record.VideoID, &record.Phase, &record.Code, &record.Attempt, &record.Retryable,
+			&record.Message, &record.Detail, &record.Stderr, &record.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,39 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewErrorRecord(t *testing.T) {
+	var stderr strings.Builder
+	for i := 1; i <= 30; i++ {
+		fmt.Fprintf(&stderr, "line %d\n", i)
+	}
+	stderr.WriteString("Conversion failed!\n")
+
+	err := failure(PhaseEncoding, CodeEncodeFailed,
+		fmt.Errorf("failed to convert to MPEG-DASH: %w", &FFmpegError{Err: errors.New("exit status 1"), Stderr: stderr.String()}))
+	record := newErrorRecord(3, 2, "Error during video conversion", err)
+
+	assert.Equal(t, 3, record.VideoID)
+	assert.Equal(t, PhaseEncoding, record.Phase)
+	assert.Equal(t, CodeEncodeFailed, record.Code)
+	assert.Equal(t, 2, record.Attempt)
+	assert.True(t, record.Retryable)
+	assert.Equal(t, "failed to convert to MPEG-DASH: exit status 1: Conversion failed!", record.Detail)
+
+	// Só o fim do stderr é guardado, é onde o ffmpeg explica a falha
+	lines := strings.Split(record.Stderr, "\n")
+	assert.Len(t, lines, stderrTailLines)
+	assert.Equal(t, "line 12", lines[0])
+	assert.Equal(t, "Conversion failed!", lines[len(lines)-1])
+
+	record = newErrorRecord(3, 1, "Error during video conversion", Permanent(failure(PhaseMerging, CodeMergeFailed, errors.New("no chunks"))))
+	assert.Empty(t, record.Stderr)
+	assert.False(t, record.Retryable)
+}
@@ -0,0 +1,128 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// stderrTailLines is how many lines of the ffmpeg stderr are kept in the error log
+const stderrTailLines = 20
+
+// permanentError marks a failure that will happen again on every attempt
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that the conversion is not retried
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsRetryable reports whether a failed conversion may succeed on a later attempt.
+// Invalid media and errors wrapped with Permanent are final, anything else
+// (disk, ffmpeg crashes, timeouts, broker hiccups) is retried.
+func IsRetryable(err error) bool {
+	var permanent *permanentError
+	var invalid *InvalidMediaError
+	return !errors.As(err, &permanent) && !errors.As(err, &invalid)
+}
+
+// ErrorCode classifies the failure of a conversion for the failure events and the error log
+type ErrorCode string
+
+const (
+	CodeInvalidMessage ErrorCode = "invalid_message"
+	CodeClaimFailed    ErrorCode = "claim_failed"
+	CodeMergeFailed    ErrorCode = "merge_failed"
+	CodeStorageFailed  ErrorCode = "storage_failed"
+	CodeProbeFailed    ErrorCode = "probe_failed"
+	CodeProbeInvalid   ErrorCode = "probe_invalid"
+	CodeEncodeFailed   ErrorCode = "encode_failed"
+	CodeEncodeTimeout  ErrorCode = "encode_timeout"
+	CodeMarkFailed     ErrorCode = "mark_failed"
+	CodePublishFailed  ErrorCode = "publish_failed"
+	CodeUnknown        ErrorCode = "unknown"
+)
+
+// errorMessages are the user facing descriptions of each code
+var errorMessages = map[ErrorCode]string{
+	CodeInvalidMessage: "The conversion request could not be read",
+	CodeClaimFailed:    "The conversion job could not be started",
+	CodeMergeFailed:    "The uploaded chunks could not be merged",
+	CodeStorageFailed:  "The converted files could not be written to storage",
+	CodeProbeFailed:    "The uploaded file could not be inspected",
+	CodeProbeInvalid:   "The uploaded file is not a valid video",
+	CodeEncodeFailed:   "The video could not be encoded",
+	CodeEncodeTimeout:  "Encoding the video took too long",
+	CodeMarkFailed:     "The conversion could not be recorded",
+	CodePublishFailed:  "The conversion result could not be published",
+	CodeUnknown:        "The video could not be converted",
+}
+
+// Message returns the user facing description of the code
+func (c ErrorCode) Message() string {
+	if message, ok := errorMessages[c]; ok {
+		return message
+	}
+	return errorMessages[CodeUnknown]
+}
+
+// ConversionError is a failure of one phase of the conversion
+type ConversionError struct {
+	Phase Phase
+	Code  ErrorCode
+	Err   error
+}
+
+func (e *ConversionError) Error() string { return e.Err.Error() }
+func (e *ConversionError) Unwrap() error { return e.Err }
+
+// failure wraps err as a ConversionError of the phase
+func failure(phase Phase, code ErrorCode, err error) error {
+	return &ConversionError{Phase: phase, Code: code, Err: err}
+}
+
+// classify returns the phase and code of a conversion error, CodeUnknown when err is not a ConversionError
+func classify(err error) (Phase, ErrorCode) {
+	var conversionErr *ConversionError
+	if errors.As(err, &conversionErr) {
+		return conversionErr.Phase, conversionErr.Code
+	}
+	return "", CodeUnknown
+}
+
+// FFmpegError is a failed ffmpeg run, carrying the end of its stderr where ffmpeg explains the failure
+type FFmpegError struct {
+	Err    error
+	Stderr string
+}
+
+func (e *FFmpegError) Error() string {
+	lines := strings.Split(strings.TrimSpace(e.Stderr), "\n")
+	if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+		return fmt.Sprintf("%v: %s", e.Err, last)
+	}
+	return e.Err.Error()
+}
+
+func (e *FFmpegError) Unwrap() error { return e.Err }
+
+// stderrTail returns the last lines of the ffmpeg stderr carried by err, empty when err is not an FFmpegError
+func stderrTail(err error) string {
+	var ffmpegErr *FFmpegError
+	if !errors.As(err, &ffmpegErr) {
+		return ""
+	}
+	lines := strings.Split(strings.TrimSpace(ffmpegErr.Stderr), "\n")
+	if len(lines) > stderrTailLines {
+		lines = lines[len(lines)-stderrTailLines:]
+	}
+	return strings.Join(lines, "\n")
+}
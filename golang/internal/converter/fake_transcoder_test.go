@@ -0,0 +1,128 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeTranscoder is an in-process Transcoder, compiled only with the tests. It reports Info as the probe result and
+// writes a synthetic manifest and empty images instead of encoding, or fails with the configured errors.
+type FakeTranscoder struct {
+	Info          MediaInfo
+	ProbeErr      error
+	TranscodeErr  error
+	ThumbnailsErr error
+	Delay         time.Duration // Simulated encoding time, interrupted by the context
+
+	mu         sync.Mutex
+	jobs       []TranscodeJob
+	thumbnails []ThumbnailJob
+}
+
+// NewFakeTranscoder creates a FakeTranscoder reporting a 1080p source with audio
+func NewFakeTranscoder() *FakeTranscoder {
+	return &FakeTranscoder{
+		Info: MediaInfo{
+			Duration:      60,
+			Container:     "mov,mp4,m4a,3gp,3g2,mj2",
+			VideoCodec:    "h264",
+			AudioCodec:    "aac",
+			Width:         1920,
+			Height:        1080,
+			FrameRate:     30,
+			AudioChannels: 2,
+			HasAudio:      true,
+		},
+	}
+}
+
+// Probe returns the configured media info
+func (f *FakeTranscoder) Probe(ctx context.Context, inputFile string) (*MediaInfo, error) {
+	if f.ProbeErr != nil {
+		return nil, f.ProbeErr
+	}
+	info := f.Info
+	return &info, nil
+}
+
+// Transcode records the job and writes a manifest listing one Representation per rendition
+func (f *FakeTranscoder) Transcode(ctx context.Context, job TranscodeJob) error {
+	f.mu.Lock()
+	f.jobs = append(f.jobs, job)
+	f.mu.Unlock()
+
+	if f.Delay > 0 {
+		select {
+		case <-time.After(f.Delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if f.TranscodeErr != nil {
+		return f.TranscodeErr
+	}
+
+	if job.Progress != nil {
+		half := time.Duration(job.Source.Duration / 2 * float64(time.Second))
+		job.Progress(TranscodeProgress{OutTime: half, Speed: 2})
+		job.Progress(TranscodeProgress{OutTime: 2 * half, Speed: 2, Done: true})
+	}
+
+	var mpd, m3u8 strings.Builder
+	mpd.WriteString("<?xml version=\"1.0\"?>\n<MPD xmlns=\"urn:mpeg:dash:schema:mpd:2011\" type=\"static\">\n<Period>\n<AdaptationSet id=\"0\" contentType=\"video\">\n")
+	m3u8.WriteString("#EXTM3U\n")
+	for i, r := range job.Renditions {
+		fmt.Fprintf(&mpd, "<Representation id=\"%d\" width=\"%d\" height=\"%d\" bandwidth=\"%d\"/>\n", i, r.Width, r.Height, r.VideoKbps*1000)
+		fmt.Fprintf(&m3u8, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\nmedia_%d.m3u8\n", r.VideoKbps*1000, r.Width, r.Height, i)
+	}
+	mpd.WriteString("</AdaptationSet>\n</Period>\n</MPD>\n")
+
+	if err := os.WriteFile(filepath.Join(job.OutputDir, dashManifestName), []byte(mpd.String()), 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(job.OutputDir, hlsManifestName), []byte(m3u8.String()), 0o644)
+}
+
+// Thumbnails records the job and writes an empty image for every frame and sprite sheet
+func (f *FakeTranscoder) Thumbnails(ctx context.Context, job ThumbnailJob) error {
+	f.mu.Lock()
+	f.thumbnails = append(f.thumbnails, job)
+	f.mu.Unlock()
+
+	if f.ThumbnailsErr != nil {
+		return f.ThumbnailsErr
+	}
+
+	files := make([]string, 0, len(job.Frames)+job.Sprite.Sheets)
+	for _, frame := range job.Frames {
+		files = append(files, frame.File)
+	}
+	for i := 0; i < job.Sprite.Sheets; i++ {
+		files = append(files, fmt.Sprintf(job.Sprite.Pattern, i))
+	}
+	for _, file := range files {
+		if err := os.WriteFile(file, nil, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ThumbnailJobs returns the thumbnail jobs received so far
+func (f *FakeTranscoder) ThumbnailJobs() []ThumbnailJob {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]ThumbnailJob(nil), f.thumbnails...)
+}
+
+// Jobs returns the jobs received so far
+func (f *FakeTranscoder) Jobs() []TranscodeJob {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]TranscodeJob(nil), f.jobs...)
+}
@@ -0,0 +1,214 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	segmentSeconds   = 4             // Target duration of each segment
+	dashManifestName = "output.mpd"  // MPEG-DASH manifest
+	hlsManifestName  = "master.m3u8" // HLS master playlist, sharing the DASH segments
+)
+
+// FFmpegTranscoder is the Transcoder backed by the ffmpeg and ffprobe binaries
+type FFmpegTranscoder struct {
+	ffmpegPath  string
+	ffprobePath string
+}
+
+// NewFFmpegTranscoder creates a Transcoder that runs the ffmpeg and ffprobe binaries found in the PATH
+func NewFFmpegTranscoder() *FFmpegTranscoder {
+	return &FFmpegTranscoder{
+		ffmpegPath:  "ffmpeg",
+		ffprobePath: "ffprobe",
+	}
+}
+
+// CheckBinaries verifies that the ffmpeg and ffprobe binaries can be found
+func (t *FFmpegTranscoder) CheckBinaries() error {
+	for _, name := range []string{t.ffmpegPath, t.ffprobePath} {
+		if _, err := exec.LookPath(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// killGracePeriod is how long ffmpeg gets to exit after a SIGTERM before it is killed
+const killGracePeriod = 10 * time.Second
+
+// command builds an ffmpeg/ffprobe invocation bound to ctx, terminating the whole process group on cancellation
+func (t *FFmpegTranscoder) command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(cmd)
+	cmd.WaitDelay = killGracePeriod
+	return cmd
+}
+
+// Probe reads the container, codecs and stream properties of the input file using ffprobe.
+// A file ffprobe cannot parse is reported as an InvalidMediaError, other failures are retried.
+func (t *FFmpegTranscoder) Probe(ctx context.Context, inputFile string) (*MediaInfo, error) {
+	ffprobeCmd := t.command(ctx,
+		t.ffprobePath, "-v", "error",
+		"-show_format", "-show_streams",
+		"-of", "json",
+		inputFile,
+	)
+
+	output, err := ffprobeCmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, probeError(inputFile, exitErr, string(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("failed to probe %s: %v", inputFile, err)
+	}
+	return parseProbeOutput(output)
+}
+
+// invalidMediaMessages are the ffprobe errors for an input it cannot parse
+var invalidMediaMessages = []string{
+	"Invalid data found when processing input",
+	"moov atom not found",
+	"Could not find codec parameters",
+	"does not contain any stream",
+}
+
+// probeError classifies a failed ffprobe run: a format or parse error is invalid media, anything else
+// (a missing or unreadable file, a crash, running out of memory) may succeed on a later attempt
+func probeError(inputFile string, err error, stderr string) error {
+	for _, message := range invalidMediaMessages {
+		if strings.Contains(stderr, message) {
+			return &InvalidMediaError{Reason: strings.TrimSpace(stderr)}
+		}
+	}
+	return fmt.Errorf("failed to probe %s: %w", inputFile, &FFmpegError{Err: err, Stderr: stderr})
+}
+
+// Transcode encodes every rendition of the job into MPEG-DASH and HLS using ffmpeg,
+// following the encoding position through the -progress output
+func (t *FFmpegTranscoder) Transcode(ctx context.Context, job TranscodeJob) error {
+	args := append([]string{"-progress", "pipe:1", "-nostats"}, dashArgs(job.InputFile, job.OutputDir, job.Renditions, job.Source.HasAudio)...)
+	ffmpegCmd := t.command(ctx, t.ffmpegPath, args...)
+
+	var stderr bytes.Buffer
+	ffmpegCmd.Stderr = &stderr
+	stdout, err := ffmpegCmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := ffmpegCmd.Start(); err != nil {
+		return err
+	}
+
+	parseProgress(stdout, func(p TranscodeProgress) {
+		if job.Progress != nil {
+			job.Progress(p)
+		}
+	})
+
+	if err := ffmpegCmd.Wait(); err != nil {
+		return &FFmpegError{Err: err, Stderr: stderr.String()}
+	}
+	return nil
+}
+
+// Thumbnails extracts each frame with a fast input seek, then samples the whole video into sprite sheets
+func (t *FFmpegTranscoder) Thumbnails(ctx context.Context, job ThumbnailJob) error {
+	for _, frame := range job.Frames {
+		ffmpegCmd := t.command(ctx, t.ffmpegPath,
+			"-y", "-ss", strconv.FormatFloat(frame.At, 'f', 3, 64), "-i", job.InputFile,
+			"-frames:v", "1", "-vf", fmt.Sprintf("scale=%d:-2", job.FrameWidth), "-q:v", "3",
+			frame.File,
+		)
+		if output, err := ffmpegCmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to extract frame at %.3fs: %w", frame.At, &FFmpegError{Err: err, Stderr: string(output)})
+		}
+	}
+
+	ffmpegCmd := t.command(ctx, t.ffmpegPath,
+		"-y", "-i", job.InputFile,
+		"-an", "-vf", spriteFilter(job.Sprite), "-q:v", "4",
+		"-start_number", "0", job.Sprite.Pattern,
+	)
+	if output, err := ffmpegCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to build sprite sheets: %w", &FFmpegError{Err: err, Stderr: string(output)})
+	}
+	return nil
+}
+
+// dashArgs builds the ffmpeg arguments that encode every rendition into a single MPEG-DASH manifest.
+// The segments are fragmented MP4 (CMAF), so the HLS playlists written next to the manifest reuse them.
+func dashArgs(inputFile, outputDir string, renditions Ladder, hasAudio bool) []string {
+	args := []string{"-y", "-i", inputFile}
+
+	for range renditions {
+		args = append(args, "-map", "0:v:0")
+	}
+	if hasAudio {
+		for range renditions {
+			args = append(args, "-map", "0:a:0")
+		}
+	}
+
+	args = append(args,
+		"-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p",
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", segmentSeconds),
+		"-sc_threshold", "0",
+	)
+	for i, r := range renditions {
+		idx := strconv.Itoa(i)
+		args = append(args,
+			"-filter:v:"+idx, fmt.Sprintf("scale=-2:%d", r.Height),
+			"-b:v:"+idx, fmt.Sprintf("%dk", r.VideoKbps),
+			"-maxrate:v:"+idx, fmt.Sprintf("%dk", r.VideoKbps*107/100),
+			"-bufsize:v:"+idx, fmt.Sprintf("%dk", r.VideoKbps*2),
+		)
+	}
+
+	adaptationSets := "id=0,streams=v"
+	if hasAudio {
+		args = append(args, "-c:a", "aac")
+		for i, r := range renditions {
+			idx := strconv.Itoa(i)
+			args = append(args,
+				"-b:a:"+idx, fmt.Sprintf("%dk", r.AudioKbps),
+				"-ac:a:"+idx, strconv.Itoa(r.AudioChannels),
+			)
+		}
+		adaptationSets += " id=1,streams=a"
+	}
+
+	args = append(args,
+		"-f", "dash",
+		"-dash_segment_type", "mp4",
+		"-seg_duration", strconv.Itoa(segmentSeconds),
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-adaptation_sets", adaptationSets,
+		"-hls_playlist", "1",
+		"-hls_master_name", hlsManifestName,
+		filepath.Join(outputDir, dashManifestName),
+	)
+	return args
+}
+
+// renditionNames returns the names of the renditions, used for logging
+func renditionNames(renditions Ladder) string {
+	names := make([]string, len(renditions))
+	for i, r := range renditions {
+		names[i] = r.Name
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,88 @@
+package converter
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// argValue returns the value following the first occurrence of flag
+func argValue(args []string, flag string) string {
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == flag {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func TestDashArgs(t *testing.T) {
+	renditions := DefaultLadder().ForSource(1280, 720)
+	tests := []struct {
+		name           string
+		hasAudio       bool
+		videoMaps      int
+		audioMaps      int
+		adaptationSets string
+	}{
+		{"with audio", true, len(renditions), len(renditions), "id=0,streams=v id=1,streams=a"},
+		{"without audio", false, len(renditions), 0, "id=0,streams=v"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := dashArgs("merged.mp4", "out", renditions, tt.hasAudio)
+
+			maps := map[string]int{}
+			for i, arg := range args[:len(args)-1] {
+				if arg == "-map" {
+					maps[args[i+1]]++
+				}
+			}
+			assert.Equal(t, tt.videoMaps, maps["0:v:0"])
+			assert.Equal(t, tt.audioMaps, maps["0:a:0"])
+			assert.Equal(t, tt.adaptationSets, argValue(args, "-adaptation_sets"))
+			if tt.hasAudio {
+				assert.Equal(t, "aac", argValue(args, "-c:a"))
+			} else {
+				assert.NotContains(t, args, "-c:a")
+			}
+
+			// Segmentos CMAF compartilhados pelo manifesto DASH e pelas playlists HLS
+			assert.Equal(t, "dash", argValue(args, "-f"))
+			assert.Equal(t, "mp4", argValue(args, "-dash_segment_type"))
+			assert.Equal(t, "1", argValue(args, "-hls_playlist"))
+			assert.Equal(t, hlsManifestName, argValue(args, "-hls_master_name"))
+			assert.Equal(t, filepath.Join("out", dashManifestName), args[len(args)-1])
+			assert.Equal(t, "scale=-2:720", argValue(args, "-filter:v:0"))
+		})
+	}
+}
+
+func TestProbeError(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+	tests := []struct {
+		name      string
+		stderr    string
+		retryable bool
+	}{
+		{"invalid data", "merged.mp4: Invalid data found when processing input\n", false},
+		{"truncated mp4", "[mov,mp4,m4a,3gp,3g2,mj2 @ 0x5581] moov atom not found\nmerged.mp4: Invalid data found when processing input\n", false},
+		{"no streams", "merged.mp4: does not contain any stream\n", false},
+		{"missing file", "merged.mp4: No such file or directory\n", true},
+		{"out of memory", "merged.mp4: Cannot allocate memory\n", true},
+		{"no stderr", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := probeError("merged.mp4", exitErr, tt.stderr)
+			assert.Equal(t, tt.retryable, IsRetryable(err))
+			if tt.retryable {
+				// O stderr vai para o registro de erros
+				assert.Equal(t, strings.TrimSpace(tt.stderr), stderrTail(err))
+			}
+		})
+	}
+}
@@ -0,0 +1,860 @@
+package converter_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"imersaofc/internal/converter"
+	"imersaofc/pkg/contracts"
+	"imersaofc/pkg/rabbitmq"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fakePublisher is an in-memory RabbitClientInterface that records published messages
+type fakePublisher struct {
+	mu         sync.Mutex
+	published  []publishedMessage
+	retries    []retriedMessage
+	parked     []retriedMessage
+	publishErr error
+}
+
+type retriedMessage struct {
+	Queue     string
+	Retryable bool
+	Dead      bool
+	Reason    string
+}
+
+type publishedMessage struct {
+	Exchange   string
+	RoutingKey string
+	Queue      string
+	Body       []byte
+	Headers    amqp.Table
+	TraceID    trace.TraceID // Trace of the context the message was published with
+}
+
+func (p *fakePublisher) ConsumeMessages(exchange, routingKey, queueName string) (<-chan amqp.Delivery, error) {
+	return make(chan amqp.Delivery), nil
+}
+
+func (p *fakePublisher) PublishMessage(ctx context.Context, exchange, routingKey, queueName string, message []byte, headers amqp.Table) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.publishErr != nil {
+		return p.publishErr
+	}
+	p.published = append(p.published, publishedMessage{exchange, routingKey, queueName, message, headers, trace.SpanContextFromContext(ctx).TraceID()})
+	return nil
+}
+
+func (p *fakePublisher) Retry(d amqp.Delivery, queueName string, policy rabbitmq.RetryPolicy, retryable bool, reason string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	dead := !retryable || rabbitmq.RetryCount(d)+1 >= policy.MaxAttempts
+	p.retries = append(p.retries, retriedMessage{queueName, retryable, dead, reason})
+	return dead, d.Ack(false)
+}
+
+func (p *fakePublisher) Park(d amqp.Delivery, queueName string, reason string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.parked = append(p.parked, retriedMessage{Queue: queueName, Reason: reason})
+	return d.Ack(false)
+}
+
+// routedTo returns the bodies published with the routing key
+func (p *fakePublisher) routedTo(routingKey string) [][]byte {
+	var bodies [][]byte
+	for _, msg := range p.messages() {
+		if msg.RoutingKey == routingKey {
+			bodies = append(bodies, msg.Body)
+		}
+	}
+	return bodies
+}
+
+// failures decodes the conversion-failed events
+func (p *fakePublisher) failures(t *testing.T) []converter.ConversionFailure {
+	var events []converter.ConversionFailure
+	for _, body := range p.routedTo("conversion-failed") {
+		var event converter.ConversionFailure
+		assert.NoError(t, json.Unmarshal(body, &event))
+		events = append(events, event)
+	}
+	return events
+}
+
+func (p *fakePublisher) retried() []retriedMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]retriedMessage(nil), p.retries...)
+}
+
+func (p *fakePublisher) Close() error   { return nil }
+func (p *fakePublisher) IsClosed() bool { return false }
+
+func (p *fakePublisher) messages() []publishedMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]publishedMessage(nil), p.published...)
+}
+
+// fakeAcknowledger records how a delivery was settled
+type fakeAcknowledger struct {
+	acked, nacked, requeued bool
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = true
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	a.nacked, a.requeued = true, requeue
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+// newDelivery builds a delivery carrying the given task
+func newDelivery(t *testing.T, task converter.VideoTask) (amqp.Delivery, *fakeAcknowledger) {
+	body, err := json.Marshal(task)
+	assert.NoError(t, err)
+	ack := &fakeAcknowledger{}
+	return amqp.Delivery{Acknowledger: ack, Body: body}, ack
+}
+
+// writeChunks creates a folder with a few chunks for the video under rootPath
+func writeChunks(t *testing.T, rootPath string, videoID int) {
+	chunkPath := filepath.Join(rootPath, fmt.Sprintf("%d", videoID))
+	assert.NoError(t, os.MkdirAll(chunkPath, os.ModePerm))
+	for i := 0; i < 3; i++ {
+		err := os.WriteFile(filepath.Join(chunkPath, fmt.Sprintf("%d.chunk", i)), []byte{byte(i)}, 0o644)
+		assert.NoError(t, err)
+	}
+}
+
+const (
+	claimJobQuery      = `INSERT INTO conversion_jobs`
+	getJobQuery        = `SELECT (.+) FROM conversion_jobs WHERE video_id`
+	renewLeaseQuery    = `UPDATE conversion_jobs SET lease_expires_at`
+	startPhaseQuery    = `UPDATE conversion_jobs SET phase`
+	transitionQuery    = `UPDATE conversion_jobs SET state`
+	markQuery          = `UPDATE conversion_jobs SET state = 'succeeded'`
+	saveMetadataQuery  = `INSERT INTO video_metadata`
+	registerErrorQuery = `INSERT INTO process_errors_log`
+	enqueueOutboxQuery = `INSERT INTO outbox`
+)
+
+// jobRows returns a conversion_jobs row as selected by the job queries
+func jobRows(videoID int, state converter.JobState, attempts int, workerID string) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows([]string{"video_id", "path", "state", "attempts", "worker_id", "lease_expires_at", "phase", "last_error",
+		"created_at", "updated_at", "started_at", "merging_at", "probing_at", "encoding_at", "packaging_at", "finished_at"}).
+		AddRow(videoID, fmt.Sprintf("/media/uploads/%d", videoID), string(state), attempts, workerID, now.Add(time.Minute), "", "",
+			now, now, now, nil, nil, nil, nil, nil)
+}
+
+// expectClaim expects the job of the video to be claimed by the test worker
+func expectClaim(mock sqlmock.Sqlmock, videoID int) {
+	mock.ExpectQuery(claimJobQuery).WithArgs(videoID, fmt.Sprintf("/media/uploads/%d", videoID), "test-worker", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(jobRows(videoID, converter.JobRunning, 1, "test-worker"))
+}
+
+// expectPhases expects the running job of the video to enter each phase in order
+func expectPhases(mock sqlmock.Sqlmock, videoID int, phases ...converter.Phase) {
+	for _, phase := range phases {
+		mock.ExpectExec(startPhaseQuery).WithArgs(string(phase), sqlmock.AnyArg(), videoID).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+}
+
+// expectTransition expects the worker to release the job of the video to the given state
+func expectTransition(mock sqlmock.Sqlmock, videoID int, state converter.JobState) {
+	mock.ExpectExec(transitionQuery).WithArgs(string(state), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), videoID, "test-worker").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// capture is a sqlmock argument that matches any value and keeps it
+type capture struct{ value driver.Value }
+
+func (c *capture) Match(value driver.Value) bool {
+	c.value = value
+	return true
+}
+
+// queuedMessage holds the arguments of a message inserted in the outbox
+type queuedMessage struct {
+	routingKey, payload, headers capture
+}
+
+// body returns the payload of the queued message
+func (m *queuedMessage) body() []byte {
+	payload, _ := m.payload.value.(string)
+	return []byte(payload)
+}
+
+// expectMarkProcessed expects the job of the video to succeed with its confirmation queued in the outbox,
+// in a single transaction
+func expectMarkProcessed(mock sqlmock.Sqlmock, videoID int) *queuedMessage {
+	queued := &queuedMessage{}
+	mock.ExpectBegin()
+	mock.ExpectExec(markQuery).WithArgs(sqlmock.AnyArg(), videoID, "test-worker").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(enqueueOutboxQuery).WithArgs(videoID, "conversion_exchange", &queued.routingKey, "video_confirmation_queue",
+		&queued.payload, &queued.headers, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	return queued
+}
+
+type handlerFixture struct {
+	converter  *converter.VideoConverter
+	transcoder *converter.FakeTranscoder
+	publisher  *fakePublisher
+	mock       sqlmock.Sqlmock
+	rootPath   string
+}
+
+func newHandlerFixture(t *testing.T) *handlerFixture {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	f := &handlerFixture{
+		transcoder: converter.NewFakeTranscoder(),
+		publisher:  &fakePublisher{},
+		mock:       mock,
+		rootPath:   t.TempDir(),
+	}
+	f.converter = converter.NewVideoConverter(f.publisher, converter.NewPostgresStore(db), f.transcoder, converter.Config{
+		RootPath:     f.rootPath,
+		WorkerID:     "test-worker",
+		QueueName:    "video_conversion_queue",
+		FailureKey:   "conversion-failed",
+		FailureQueue: "video_failure_queue",
+	})
+	return f
+}
+
+func (f *handlerFixture) handle(t *testing.T, task converter.VideoTask) *fakeAcknowledger {
+	d, ack := newDelivery(t, task)
+	f.converter.HandleMessage(context.Background(), d, "conversion_exchange", "finish-conversion", "video_confirmation_queue")
+	assert.NoError(t, f.mock.ExpectationsWereMet())
+	return ack
+}
+
+func TestHandleMessageConvertsVideo(t *testing.T) {
+	f := newHandlerFixture(t)
+	writeChunks(t, f.rootPath, 1)
+
+	expectClaim(f.mock, 1)
+	expectPhases(f.mock, 1, converter.PhaseMerging, converter.PhaseProbing)
+	f.mock.ExpectExec(saveMetadataQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPhases(f.mock, 1, converter.PhaseEncoding, converter.PhasePackaging)
+	confirmation := expectMarkProcessed(f.mock, 1)
+
+	ack := f.handle(t, converter.VideoTask{VideoID: 1, Path: "/media/uploads/1"})
+	assert.True(t, ack.acked)
+
+	// Every rung of the default ladder fits a 1080p source
+	jobs := f.transcoder.Jobs()
+	assert.Len(t, jobs, 1)
+	assert.Len(t, jobs[0].Renditions, len(converter.DefaultLadder()))
+	assert.FileExists(t, filepath.Join(f.rootPath, "1", "mpeg-dash", "output.mpd"))
+	assert.NoFileExists(t, filepath.Join(f.rootPath, "1", "merged.mp4"))
+
+	// A confirmação fica no outbox, quem publica é o relay
+	assert.Empty(t, f.publisher.messages())
+	assert.Equal(t, "finish-conversion", confirmation.routingKey.value)
+
+	var headers map[string]string
+	assert.NoError(t, json.Unmarshal([]byte(confirmation.headers.value.(string)), &headers))
+	assert.Equal(t, "conversion.completed", headers[contracts.TypeHeader])
+	assert.Equal(t, "1", headers[contracts.VersionHeader])
+
+	var result converter.ConversionResult
+	assert.NoError(t, json.Unmarshal(confirmation.body(), &result))
+	assert.Equal(t, converter.ConversionResult{
+		VideoID:      1,
+		Path:         "/media/uploads/1",
+		DashManifest: "/media/uploads/1/mpeg-dash/output.mpd",
+		HLSManifest:  "/media/uploads/1/mpeg-dash/master.m3u8",
+		Metadata:     &f.transcoder.Info,
+		Thumbnails: &converter.ThumbnailSet{
+			Poster: "/media/uploads/1/thumbnails/poster.jpg",
+			Thumbnails: []string{
+				"/media/uploads/1/thumbnails/thumbnail-01.jpg",
+				"/media/uploads/1/thumbnails/thumbnail-02.jpg",
+				"/media/uploads/1/thumbnails/thumbnail-03.jpg",
+				"/media/uploads/1/thumbnails/thumbnail-04.jpg",
+				"/media/uploads/1/thumbnails/thumbnail-05.jpg",
+			},
+			SpriteTrack: "/media/uploads/1/thumbnails/thumbnails.vtt",
+			Sprites:     []string{"/media/uploads/1/thumbnails/sprite-000.jpg"},
+		},
+	}, result)
+	assert.FileExists(t, filepath.Join(f.rootPath, "1", "thumbnails", "thumbnails.vtt"))
+}
+
+func TestHandleMessagePublishesProgress(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	rootPath := t.TempDir()
+	publisher := &fakePublisher{}
+	vc := converter.NewVideoConverter(publisher, converter.NewPostgresStore(db), converter.NewFakeTranscoder(), converter.Config{
+		RootPath:      rootPath,
+		WorkerID:      "test-worker",
+		ProgressKey:   "conversion-progress",
+		ProgressQueue: "video_progress_queue",
+	})
+	writeChunks(t, rootPath, 1)
+
+	expectClaim(mock, 1)
+	expectPhases(mock, 1, converter.PhaseMerging, converter.PhaseProbing)
+	mock.ExpectExec(saveMetadataQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPhases(mock, 1, converter.PhaseEncoding, converter.PhasePackaging)
+	expectMarkProcessed(mock, 1)
+
+	d, _ := newDelivery(t, converter.VideoTask{VideoID: 1, Path: "/media/uploads/1"})
+	vc.HandleMessage(context.Background(), d, "conversion_exchange", "finish-conversion", "video_confirmation_queue")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Cada fase publica ao menos o início e o fim
+	phases := map[converter.Phase]bool{}
+	for _, msg := range publisher.messages() {
+		assert.Equal(t, "conversion-progress", msg.RoutingKey)
+		var event converter.ProgressEvent
+		assert.NoError(t, json.Unmarshal(msg.Body, &event))
+		assert.Equal(t, 1, event.VideoID)
+		phases[event.Phase] = true
+	}
+	assert.Len(t, phases, 4)
+}
+
+func TestHandleMessageSkipsProcessedVideo(t *testing.T) {
+	f := newHandlerFixture(t)
+	writeChunks(t, f.rootPath, 1)
+
+	f.mock.ExpectQuery(claimJobQuery).WithArgs(1, "/media/uploads/1", "test-worker", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(nil))
+	f.mock.ExpectQuery(getJobQuery).WithArgs(1).WillReturnRows(jobRows(1, converter.JobSucceeded, 1, "other-worker"))
+
+	ack := f.handle(t, converter.VideoTask{VideoID: 1, Path: "/media/uploads/1"})
+	assert.True(t, ack.acked)
+	assert.Empty(t, f.transcoder.Jobs())
+	assert.Empty(t, f.publisher.messages())
+}
+
+func TestHandleMessageForceReprocess(t *testing.T) {
+	f := newHandlerFixture(t)
+	writeChunks(t, f.rootPath, 1)
+
+	// Saída de uma conversão anterior
+	videoDir := filepath.Join(f.rootPath, "1")
+	assert.NoError(t, os.MkdirAll(filepath.Join(videoDir, "mpeg-dash"), os.ModePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(videoDir, "mpeg-dash", "output.mpd"), []byte("old"), 0o644))
+
+	f.mock.ExpectQuery(`WHERE conversion_jobs.state IN \('queued','failed','succeeded','cancelled'\)`).
+		WithArgs(1, "/media/uploads/1", "test-worker", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(jobRows(1, converter.JobRunning, 2, "test-worker"))
+	expectPhases(f.mock, 1, converter.PhaseMerging, converter.PhaseProbing)
+	f.mock.ExpectExec(saveMetadataQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPhases(f.mock, 1, converter.PhaseEncoding, converter.PhasePackaging)
+	confirmation := expectMarkProcessed(f.mock, 1)
+
+	ack := f.handle(t, converter.VideoTask{VideoID: 1, Path: "/media/uploads/1", Force: true})
+	assert.True(t, ack.acked)
+	assert.Len(t, f.transcoder.Jobs(), 1)
+	assert.NotEmpty(t, confirmation.body())
+
+	// The old manifest is archived and replaced by the new one
+	archived, err := filepath.Glob(filepath.Join(videoDir, "archive", "*", "mpeg-dash", "output.mpd"))
+	assert.NoError(t, err)
+	assert.Len(t, archived, 1)
+	content, err := os.ReadFile(archived[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "old", string(content))
+	assert.FileExists(t, filepath.Join(videoDir, "mpeg-dash", "output.mpd"))
+}
+
+func TestHandleMessageForceReprocessRestoresOutput(t *testing.T) {
+	f := newHandlerFixture(t)
+	f.transcoder.TranscodeErr = errors.New("encoder crashed")
+	writeChunks(t, f.rootPath, 1)
+
+	videoDir := filepath.Join(f.rootPath, "1")
+	assert.NoError(t, os.MkdirAll(filepath.Join(videoDir, "mpeg-dash"), os.ModePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(videoDir, "mpeg-dash", "output.mpd"), []byte("old"), 0o644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(videoDir, "thumbnails"), os.ModePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(videoDir, "thumbnails", "poster.jpg"), []byte("old"), 0o644))
+
+	f.mock.ExpectQuery(`WHERE conversion_jobs.state IN \('queued','failed','succeeded','cancelled'\)`).
+		WithArgs(1, "/media/uploads/1", "test-worker", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(jobRows(1, converter.JobRunning, 2, "test-worker"))
+	expectPhases(f.mock, 1, converter.PhaseMerging, converter.PhaseProbing)
+	f.mock.ExpectExec(saveMetadataQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPhases(f.mock, 1, converter.PhaseEncoding)
+	f.mock.ExpectExec(registerErrorQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectTransition(f.mock, 1, converter.JobQueued)
+
+	ack := f.handle(t, converter.VideoTask{VideoID: 1, Path: "/media/uploads/1", Force: true})
+	assert.True(t, ack.acked)
+
+	// Uma reconversão que falha devolve a saída anterior, que continua sendo servida
+	for _, file := range []string{filepath.Join("mpeg-dash", "output.mpd"), filepath.Join("thumbnails", "poster.jpg")} {
+		content, err := os.ReadFile(filepath.Join(videoDir, file))
+		assert.NoError(t, err)
+		assert.Equal(t, "old", string(content))
+	}
+	assert.NoDirExists(t, filepath.Join(videoDir, "archive"))
+	assert.NoFileExists(t, filepath.Join(videoDir, "merged.mp4"))
+}
+
+func TestHandleMessageSkipsRunningJob(t *testing.T) {
+	f := newHandlerFixture(t)
+	writeChunks(t, f.rootPath, 10)
+
+	// Outro worker já está convertendo o vídeo
+	f.mock.ExpectQuery(claimJobQuery).WithArgs(10, "/media/uploads/10", "test-worker", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(nil))
+	f.mock.ExpectQuery(getJobQuery).WithArgs(10).WillReturnRows(jobRows(10, converter.JobRunning, 1, "other-worker"))
+
+	ack := f.handle(t, converter.VideoTask{VideoID: 10, Path: "/media/uploads/10"})
+	assert.True(t, ack.acked)
+	assert.Empty(t, f.transcoder.Jobs())
+	assert.FileExists(t, filepath.Join(f.rootPath, "10", "0.chunk"))
+}
+
+func TestHandleMessageClaimFailure(t *testing.T) {
+	f := newHandlerFixture(t)
+	writeChunks(t, f.rootPath, 11)
+
+	f.mock.ExpectQuery(claimJobQuery).WithArgs(11, "/media/uploads/11", "test-worker", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnError(errors.New("connection refused"))
+	f.mock.ExpectExec(registerErrorQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	ack := f.handle(t, converter.VideoTask{VideoID: 11, Path: "/media/uploads/11"})
+	assert.True(t, ack.acked)
+	assert.Empty(t, f.transcoder.Jobs())
+
+	retries := f.publisher.retried()
+	assert.Len(t, retries, 1)
+	assert.True(t, retries[0].Retryable)
+	assert.False(t, retries[0].Dead)
+}
+
+func TestHandleMessageMergeFailure(t *testing.T) {
+	f := newHandlerFixture(t)
+
+	// Sem diretório de chunks o merge falha
+	expectClaim(f.mock, 2)
+	expectPhases(f.mock, 2, converter.PhaseMerging)
+	f.mock.ExpectExec(registerErrorQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectTransition(f.mock, 2, converter.JobQueued)
+
+	ack := f.handle(t, converter.VideoTask{VideoID: 2, Path: "/media/uploads/2"})
+	assert.True(t, ack.acked)
+	assert.Empty(t, f.transcoder.Jobs())
+	assert.Empty(t, f.publisher.routedTo("finish-conversion"))
+
+	failures := f.publisher.failures(t)
+	assert.Len(t, failures, 1)
+	assert.Equal(t, 2, failures[0].VideoID)
+	assert.Equal(t, converter.PhaseMerging, failures[0].Phase)
+	assert.Equal(t, converter.CodeMergeFailed, failures[0].Code)
+	assert.Equal(t, 1, failures[0].Attempt)
+	assert.True(t, failures[0].Retryable)
+	assert.True(t, failures[0].WillRetry)
+	assert.Equal(t, contracts.ConversionFailedV1.Headers(), f.publisher.messages()[0].Headers)
+}
+
+func TestHandleMessageInvalidMedia(t *testing.T) {
+	f := newHandlerFixture(t)
+	f.transcoder.Info = converter.MediaInfo{Container: "mp3", AudioCodec: "mp3", Duration: 30, HasAudio: true}
+	writeChunks(t, f.rootPath, 5)
+
+	// Um arquivo sem stream de vídeo é rejeitado antes da conversão
+	expectClaim(f.mock, 5)
+	expectPhases(f.mock, 5, converter.PhaseMerging, converter.PhaseProbing)
+	f.mock.ExpectExec(registerErrorQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectTransition(f.mock, 5, converter.JobFailed)
+
+	ack := f.handle(t, converter.VideoTask{VideoID: 5, Path: "/media/uploads/5"})
+	assert.True(t, ack.acked)
+	assert.Empty(t, f.transcoder.Jobs())
+	assert.Empty(t, f.publisher.routedTo("finish-conversion"))
+
+	failures := f.publisher.failures(t)
+	assert.Len(t, failures, 1)
+	assert.Equal(t, converter.PhaseProbing, failures[0].Phase)
+	assert.Equal(t, converter.CodeProbeInvalid, failures[0].Code)
+	assert.Equal(t, "The uploaded file is not a valid video", failures[0].Message)
+	assert.False(t, failures[0].Retryable)
+	assert.False(t, failures[0].WillRetry)
+
+	// Mídia inválida não é retentada
+	retries := f.publisher.retried()
+	assert.Len(t, retries, 1)
+	assert.False(t, retries[0].Retryable)
+	assert.True(t, retries[0].Dead)
+}
+
+func TestHandleMessageTranscodeFailure(t *testing.T) {
+	f := newHandlerFixture(t)
+	f.transcoder.TranscodeErr = &converter.FFmpegError{Err: errors.New("exit status 1"), Stderr: "Error while encoding\nencoder crashed\n"}
+	writeChunks(t, f.rootPath, 3)
+
+	// O erro é registrado com fase, código e o fim do stderr do ffmpeg
+	expectClaim(f.mock, 3)
+	expectPhases(f.mock, 3, converter.PhaseMerging, converter.PhaseProbing)
+	f.mock.ExpectExec(saveMetadataQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPhases(f.mock, 3, converter.PhaseEncoding)
+	f.mock.ExpectExec(registerErrorQuery).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "encode_failed", 1, true, "Error during video conversion",
+			"failed to convert to MPEG-DASH: exit status 1: encoder crashed", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectTransition(f.mock, 3, converter.JobQueued)
+
+	ack := f.handle(t, converter.VideoTask{VideoID: 3, Path: "/media/uploads/3"})
+	assert.True(t, ack.acked)
+	assert.Len(t, f.transcoder.Jobs(), 1)
+	assert.Empty(t, f.publisher.routedTo("finish-conversion"))
+
+	failures := f.publisher.failures(t)
+	assert.Len(t, failures, 1)
+	assert.Equal(t, converter.PhaseEncoding, failures[0].Phase)
+	assert.Equal(t, converter.CodeEncodeFailed, failures[0].Code)
+
+	retries := f.publisher.retried()
+	assert.Len(t, retries, 1)
+	assert.Equal(t, "video_conversion_queue", retries[0].Queue)
+	assert.True(t, retries[0].Retryable)
+	assert.False(t, retries[0].Dead)
+	assert.Contains(t, retries[0].Reason, "encoder crashed")
+}
+
+func TestHandleMessageExhaustedRetries(t *testing.T) {
+	f := newHandlerFixture(t)
+	f.transcoder.TranscodeErr = errors.New("encoder crashed")
+	writeChunks(t, f.rootPath, 9)
+
+	expectClaim(f.mock, 9)
+	expectPhases(f.mock, 9, converter.PhaseMerging, converter.PhaseProbing)
+	f.mock.ExpectExec(saveMetadataQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPhases(f.mock, 9, converter.PhaseEncoding)
+	f.mock.ExpectExec(registerErrorQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectTransition(f.mock, 9, converter.JobFailed)
+
+	// Última tentativa da política padrão
+	d, ack := newDelivery(t, converter.VideoTask{VideoID: 9, Path: "/media/uploads/9"})
+	d.Headers = amqp.Table{rabbitmq.RetryCountHeader: int32(rabbitmq.DefaultRetryPolicy().MaxAttempts - 1)}
+	f.converter.HandleMessage(context.Background(), d, "conversion_exchange", "finish-conversion", "video_confirmation_queue")
+	assert.NoError(t, f.mock.ExpectationsWereMet())
+
+	assert.True(t, ack.acked)
+	retries := f.publisher.retried()
+	assert.Len(t, retries, 1)
+	assert.True(t, retries[0].Retryable)
+	assert.True(t, retries[0].Dead)
+
+	failures := f.publisher.failures(t)
+	assert.Len(t, failures, 1)
+	assert.Equal(t, rabbitmq.DefaultRetryPolicy().MaxAttempts, failures[0].Attempt)
+	assert.False(t, failures[0].WillRetry)
+}
+
+func TestHandleMessageInvalidPayload(t *testing.T) {
+	f := newHandlerFixture(t)
+	f.mock.ExpectExec(registerErrorQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	ack := &fakeAcknowledger{}
+	d := amqp.Delivery{Acknowledger: ack, Body: []byte(`{"video_id": "abc"`)}
+	f.converter.HandleMessage(context.Background(), d, "conversion_exchange", "finish-conversion", "video_confirmation_queue")
+	assert.NoError(t, f.mock.ExpectationsWereMet())
+
+	assert.True(t, ack.acked)
+	retries := f.publisher.retried()
+	assert.Len(t, retries, 1)
+	assert.True(t, retries[0].Dead)
+}
+
+func TestHandleMessageSchemaViolation(t *testing.T) {
+	f := newHandlerFixture(t)
+	f.mock.ExpectExec(registerErrorQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// Sem o path a mensagem não segue o contrato conversion.requested v1
+	ack := &fakeAcknowledger{}
+	d := amqp.Delivery{Acknowledger: ack, Headers: contracts.ConversionRequestedV1.Headers(), Body: []byte(`{"video_id": 1}`)}
+	f.converter.HandleMessage(context.Background(), d, "conversion_exchange", "finish-conversion", "video_confirmation_queue")
+	assert.NoError(t, f.mock.ExpectationsWereMet())
+
+	assert.True(t, ack.acked)
+	retries := f.publisher.retried()
+	assert.Len(t, retries, 1)
+	assert.True(t, retries[0].Dead)
+	assert.Contains(t, retries[0].Reason, "invalid conversion.requested.v1 message")
+}
+
+func TestHandleMessageUnknownContract(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		reason  string
+	}{
+		{"newer version", amqp.Table{contracts.TypeHeader: "conversion.requested", contracts.VersionHeader: "2"}, "conversion.requested.v2"},
+		{"other type", contracts.ConversionCompletedV1.Headers(), "conversion.completed.v1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newHandlerFixture(t)
+
+			d, ack := newDelivery(t, converter.VideoTask{VideoID: 1, Path: "/media/uploads/1"})
+			d.Headers = tt.headers
+			f.converter.HandleMessage(context.Background(), d, "conversion_exchange", "finish-conversion", "video_confirmation_queue")
+			assert.NoError(t, f.mock.ExpectationsWereMet())
+
+			// Vai para a parking queue sem tocar no job
+			assert.True(t, ack.acked)
+			assert.Empty(t, f.publisher.retried())
+			assert.Equal(t, []retriedMessage{{Queue: "video_conversion_queue", Reason: "unknown message contract " + tt.reason}}, f.publisher.parked)
+		})
+	}
+}
+
+func TestHandleMessageThumbnailFailure(t *testing.T) {
+	f := newHandlerFixture(t)
+	f.transcoder.ThumbnailsErr = errors.New("no keyframes")
+	writeChunks(t, f.rootPath, 6)
+
+	expectClaim(f.mock, 6)
+	expectPhases(f.mock, 6, converter.PhaseMerging, converter.PhaseProbing)
+	f.mock.ExpectExec(saveMetadataQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPhases(f.mock, 6, converter.PhaseEncoding, converter.PhasePackaging)
+	confirmation := expectMarkProcessed(f.mock, 6)
+
+	// A conversão é confirmada mesmo sem thumbnails
+	ack := f.handle(t, converter.VideoTask{VideoID: 6, Path: "/media/uploads/6"})
+	assert.True(t, ack.acked)
+
+	var result converter.ConversionResult
+	assert.NoError(t, json.Unmarshal(confirmation.body(), &result))
+	assert.Nil(t, result.Thumbnails)
+}
+
+func TestHandleMessageCanceledRequeues(t *testing.T) {
+	f := newHandlerFixture(t)
+	f.transcoder.Delay = 5 * time.Second
+	writeChunks(t, f.rootPath, 7)
+
+	expectClaim(f.mock, 7)
+	expectPhases(f.mock, 7, converter.PhaseMerging, converter.PhaseProbing)
+	f.mock.ExpectExec(saveMetadataQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPhases(f.mock, 7, converter.PhaseEncoding)
+	expectTransition(f.mock, 7, converter.JobQueued)
+
+	// Simula o SIGTERM durante a conversão
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	d, ack := newDelivery(t, converter.VideoTask{VideoID: 7, Path: "/media/uploads/7"})
+	f.converter.HandleMessage(ctx, d, "conversion_exchange", "finish-conversion", "video_confirmation_queue")
+	assert.NoError(t, f.mock.ExpectationsWereMet())
+
+	assert.False(t, ack.acked)
+	assert.True(t, ack.nacked)
+	assert.True(t, ack.requeued)
+	assert.Empty(t, f.publisher.messages())
+
+	// Only the chunks are left for the next worker
+	assert.NoDirExists(t, filepath.Join(f.rootPath, "7", "mpeg-dash"))
+	assert.NoFileExists(t, filepath.Join(f.rootPath, "7", "merged.mp4"))
+	assert.FileExists(t, filepath.Join(f.rootPath, "7", "0.chunk"))
+}
+
+func TestHandleMessageLeaseLost(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	rootPath := t.TempDir()
+	transcoder := converter.NewFakeTranscoder()
+	transcoder.Delay = 5 * time.Second
+	vc := converter.NewVideoConverter(&fakePublisher{}, converter.NewPostgresStore(db), transcoder, converter.Config{
+		RootPath:      rootPath,
+		WorkerID:      "test-worker",
+		LeaseDuration: 150 * time.Millisecond,
+	})
+	writeChunks(t, rootPath, 12)
+
+	// O heartbeat não encontra mais o job: outro worker assumiu depois do lease expirar
+	expectClaim(mock, 12)
+	expectPhases(mock, 12, converter.PhaseMerging, converter.PhaseProbing, converter.PhaseEncoding)
+	mock.ExpectExec(saveMetadataQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(renewLeaseQuery).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 12, "test-worker").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(getJobQuery).WithArgs(12).WillReturnRows(jobRows(12, converter.JobRunning, 2, "other-worker"))
+
+	d, ack := newDelivery(t, converter.VideoTask{VideoID: 12, Path: "/media/uploads/12"})
+	start := time.Now()
+	vc.HandleMessage(context.Background(), d, "conversion_exchange", "finish-conversion", "video_confirmation_queue")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// A conversão para sem registrar erro nem mexer no job do outro worker
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.True(t, ack.acked)
+	assert.FileExists(t, filepath.Join(rootPath, "12", "merged.mp4"))
+}
+
+func TestHandleMessageCancelledWhileEncoding(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	rootPath := t.TempDir()
+	publisher := &fakePublisher{}
+	transcoder := converter.NewFakeTranscoder()
+	transcoder.Delay = 5 * time.Second
+	vc := converter.NewVideoConverter(publisher, converter.NewPostgresStore(db), transcoder, converter.Config{
+		RootPath:      rootPath,
+		WorkerID:      "test-worker",
+		QueueName:     "video_conversion_queue",
+		LeaseDuration: 150 * time.Millisecond,
+	})
+	writeChunks(t, rootPath, 13)
+
+	// O heartbeat não encontra mais o job porque um operador o cancelou
+	expectClaim(mock, 13)
+	expectPhases(mock, 13, converter.PhaseMerging, converter.PhaseProbing, converter.PhaseEncoding)
+	mock.ExpectExec(saveMetadataQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(renewLeaseQuery).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 13, "test-worker").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(getJobQuery).WithArgs(13).WillReturnRows(jobRows(13, converter.JobCancelled, 1, "test-worker"))
+
+	d, ack := newDelivery(t, converter.VideoTask{VideoID: 13, Path: "/media/uploads/13"})
+	start := time.Now()
+	vc.HandleMessage(context.Background(), d, "conversion_exchange", "finish-conversion", "video_confirmation_queue")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// A conversão para sem retry e sem deixar a saída parcial
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.True(t, ack.acked)
+	assert.Empty(t, publisher.retried())
+	assert.NoFileExists(t, filepath.Join(rootPath, "13", "merged.mp4"))
+	assert.NoDirExists(t, filepath.Join(rootPath, "13", "mpeg-dash"))
+	assert.FileExists(t, filepath.Join(rootPath, "13", "0.chunk"))
+}
+
+func TestHandleMessageJobTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	rootPath := t.TempDir()
+	transcoder := converter.NewFakeTranscoder()
+	transcoder.Delay = 5 * time.Second
+	vc := converter.NewVideoConverter(&fakePublisher{}, converter.NewPostgresStore(db), transcoder, converter.Config{
+		RootPath:      rootPath,
+		WorkerID:      "test-worker",
+		TimeoutBase:   50 * time.Millisecond,
+		TimeoutFactor: 0.0001,
+		TimeoutMax:    100 * time.Millisecond,
+	})
+	writeChunks(t, rootPath, 8)
+
+	expectClaim(mock, 8)
+	expectPhases(mock, 8, converter.PhaseMerging, converter.PhaseProbing)
+	mock.ExpectExec(saveMetadataQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPhases(mock, 8, converter.PhaseEncoding)
+	mock.ExpectExec(registerErrorQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectTransition(mock, 8, converter.JobFailed)
+
+	d, ack := newDelivery(t, converter.VideoTask{VideoID: 8, Path: "/media/uploads/8"})
+	vc.HandleMessage(context.Background(), d, "conversion_exchange", "finish-conversion", "video_confirmation_queue")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.True(t, ack.acked)
+	assert.NoDirExists(t, filepath.Join(rootPath, "8", "mpeg-dash"))
+}
+
+func TestHandleMessageMarkFailure(t *testing.T) {
+	f := newHandlerFixture(t)
+	writeChunks(t, f.rootPath, 4)
+
+	// Sem a marcação a confirmação também não é registrada, a mensagem volta após o atraso
+	expectClaim(f.mock, 4)
+	expectPhases(f.mock, 4, converter.PhaseMerging, converter.PhaseProbing)
+	f.mock.ExpectExec(saveMetadataQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPhases(f.mock, 4, converter.PhaseEncoding, converter.PhasePackaging)
+	f.mock.ExpectBegin()
+	f.mock.ExpectExec(markQuery).WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectExec(enqueueOutboxQuery).WillReturnError(errors.New("connection reset"))
+	f.mock.ExpectRollback()
+	f.mock.ExpectExec(registerErrorQuery).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "mark_failed", 1, true,
+		"Failed to mark video as processed", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectTransition(f.mock, 4, converter.JobQueued)
+
+	ack := f.handle(t, converter.VideoTask{VideoID: 4, Path: "/media/uploads/4"})
+	assert.True(t, ack.acked)
+	assert.Empty(t, f.publisher.routedTo("finish-conversion"))
+
+	retries := f.publisher.retried()
+	assert.Len(t, retries, 1)
+	assert.True(t, retries[0].Retryable)
+	assert.False(t, retries[0].Dead)
+}
+
+func TestHandleMessageCancelledDuringConversion(t *testing.T) {
+	f := newHandlerFixture(t)
+	writeChunks(t, f.rootPath, 4)
+
+	// O job foi cancelado, o resultado e a saída são descartados
+	expectClaim(f.mock, 4)
+	expectPhases(f.mock, 4, converter.PhaseMerging, converter.PhaseProbing)
+	f.mock.ExpectExec(saveMetadataQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPhases(f.mock, 4, converter.PhaseEncoding, converter.PhasePackaging)
+	f.mock.ExpectBegin()
+	f.mock.ExpectExec(markQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+	f.mock.ExpectRollback()
+	f.mock.ExpectQuery(getJobQuery).WithArgs(4).WillReturnRows(jobRows(4, converter.JobCancelled, 1, "test-worker"))
+
+	ack := f.handle(t, converter.VideoTask{VideoID: 4, Path: "/media/uploads/4"})
+	assert.True(t, ack.acked)
+	assert.Empty(t, f.publisher.retried())
+	assert.Empty(t, f.publisher.failures(t))
+	assert.NoDirExists(t, filepath.Join(f.rootPath, "4", "mpeg-dash"))
+	assert.NoDirExists(t, filepath.Join(f.rootPath, "4", "thumbnails"))
+	assert.FileExists(t, filepath.Join(f.rootPath, "4", "0.chunk"))
+}
+
+func TestHandleMessageTakenOverDuringConversion(t *testing.T) {
+	f := newHandlerFixture(t)
+	writeChunks(t, f.rootPath, 4)
+
+	// Outro worker assumiu o job, a saída fica para ele
+	expectClaim(f.mock, 4)
+	expectPhases(f.mock, 4, converter.PhaseMerging, converter.PhaseProbing)
+	f.mock.ExpectExec(saveMetadataQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPhases(f.mock, 4, converter.PhaseEncoding, converter.PhasePackaging)
+	f.mock.ExpectBegin()
+	f.mock.ExpectExec(markQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+	f.mock.ExpectRollback()
+	f.mock.ExpectQuery(getJobQuery).WithArgs(4).WillReturnRows(jobRows(4, converter.JobRunning, 2, "other-worker"))
+
+	ack := f.handle(t, converter.VideoTask{VideoID: 4, Path: "/media/uploads/4"})
+	assert.True(t, ack.acked)
+	assert.Empty(t, f.publisher.retried())
+	assert.FileExists(t, filepath.Join(f.rootPath, "4", "mpeg-dash", "output.mpd"))
+}
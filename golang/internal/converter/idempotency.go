@@ -1,43 +1,42 @@
 package converter
 
 import (
-	"database/sql"
-	"encoding/json"
+	"fmt"
 	"log/slog"
-	"time"
 )
 
-// IsProcessed checks if the video has already been processed successfully
-func IsProcessed(db *sql.DB, videoID int) bool {
-	var isProcessed bool
-	query := "SELECT EXISTS(SELECT 1 FROM processed_videos WHERE video_id = $1 AND status = 'success')"
-	err := db.QueryRow(query, videoID).Scan(&isProcessed)
+// MarkProcessed registers that the job of the video running on workerID has succeeded, failing with ErrLeaseLost
+// when it no longer runs there. The events are queued in the outbox in the same transaction, so they are
+// published if and only if the job succeeded.
+func (s *SQLStore) MarkProcessed(videoID int, workerID string, events ...OutboxMessage) error {
+	err := s.markProcessed(videoID, workerID, events)
 	if err != nil {
-		slog.Error("Error checking if video is processed", slog.Int("video_id", videoID), slog.String("error", err.Error()))
-		return false
+		slog.Error("Error marking video as processed", slog.Int("video_id", videoID), slog.String("error", err.Error()))
+		return err
 	}
-	return isProcessed
+	return nil
 }
 
-// MarkProcessed registers that the video has been processed successfully
-func MarkProcessed(db *sql.DB, videoID int) error {
-	query := "INSERT INTO processed_videos (video_id, status, processed_at) VALUES ($1, $2, $3)"
-	_, err := db.Exec(query, videoID, "success", time.Now())
+func (s *SQLStore) markProcessed(videoID int, workerID string, events []OutboxMessage) error {
+	tx, err := s.db.Begin()
 	if err != nil {
-		slog.Error("Error marking video as processed", slog.Int("video_id", videoID), slog.String("error", err.Error()))
 		return err
 	}
-	return nil
-}
+	defer tx.Rollback()
 
-// RegisterError stores the error details and phase history in the database
-func RegisterError(db *sql.DB, errorData map[string]interface{}, err error) {
-	serializedError, _ := json.Marshal(errorData)
-	query := "INSERT INTO process_errors_log (error_details, created_at) VALUES ($1, $2)"
-	_, dbErr := db.Exec(query, serializedError, time.Now())
-	if dbErr != nil {
-		slog.Error("Error storing error log in database", slog.String("error", dbErr.Error()))
-		return
+	query := `UPDATE conversion_jobs SET state = 'succeeded', last_error = NULL, finished_at = $1, updated_at = $1, lease_expires_at = NULL
+		WHERE video_id = $2 AND state = 'running' AND worker_id = $3`
+	result, err := tx.Exec(query, s.now(), videoID, workerID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrLeaseLost
+	}
+	for _, event := range events {
+		if err := s.enqueue(tx, event); err != nil {
+			return fmt.Errorf("failed to queue %s message: %v", event.RoutingKey, err)
+		}
 	}
-	slog.Info("Error log stored successfully", slog.String("error", err.Error()))
+	return tx.Commit()
 }
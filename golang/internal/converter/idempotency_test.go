@@ -4,17 +4,15 @@ package converter_test
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"imersaofc/internal/converter"
 	"testing"
-	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestIsProcessed(t *testing.T) {
+// setupPostgresStore starts a PostgreSQL container and returns a store on it, terminated at the end of the test
+func setupPostgresStore(t *testing.T) *converter.SQLStore {
 	ctx := context.Background()
 
 	// Setup do container PostgreSQL
@@ -22,75 +20,57 @@ func TestIsProcessed(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to setup PostgreSQL container: %v", err)
 	}
-	defer postgresContainer.Terminate(ctx)
-	defer db.Close()
+	t.Cleanup(func() {
+		db.Close()
+		postgresContainer.Terminate(ctx)
+	})
+	return converter.NewPostgresStore(db)
+}
 
-	// Simular a inserção de um vídeo processado
-	_, err = db.Exec("INSERT INTO processed_videos (video_id, status, processed_at) VALUES ($1, $2, $3)", 1, "success", time.Now())
-	assert.NoError(t, err)
+func TestMarkProcessed(t *testing.T) {
+	testMarkProcessed(t, setupPostgresStore(t))
+}
 
-	// Verificar se o vídeo foi marcado como processado
-	isProcessed := converter.IsProcessed(db, 1)
-	assert.True(t, isProcessed)
+func TestReleaseJob(t *testing.T) {
+	testReleaseJob(t, setupPostgresStore(t))
+}
 
-	// Verificar se um vídeo não processado retorna `false`
-	isProcessed = converter.IsProcessed(db, 999)
-	assert.False(t, isProcessed)
+func TestClaimJob(t *testing.T) {
+	testClaimJob(t, setupPostgresStore(t))
 }
 
-func TestMarkProcessed(t *testing.T) {
-	ctx := context.Background()
+func TestForceClaimJob(t *testing.T) {
+	testForceClaimJob(t, setupPostgresStore(t))
+}
 
-	// Setup do container PostgreSQL
-	postgresContainer, db, err := setupPostgresContainer(ctx)
-	if err != nil {
-		t.Fatalf("Failed to setup PostgreSQL container: %v", err)
-	}
-	defer postgresContainer.Terminate(ctx)
-	defer db.Close()
+func TestFindJobs(t *testing.T) {
+	testFindJobs(t, setupPostgresStore(t))
+}
 
-	// Marcar um vídeo como processado
-	err = converter.MarkProcessed(db, 2)
-	assert.NoError(t, err)
+func TestJobLease(t *testing.T) {
+	testJobLease(t, setupPostgresStore(t))
+}
 
-	// Verificar se o vídeo foi processado corretamente
-	var status string
-	err = db.QueryRow("SELECT status FROM processed_videos WHERE video_id = $1", 2).Scan(&status)
-	assert.NoError(t, err)
-	assert.Equal(t, "success", status)
+func TestOutbox(t *testing.T) {
+	testOutbox(t, setupPostgresStore(t))
 }
 
 func TestRegisterError(t *testing.T) {
-	ctx := context.Background()
-
-	// Setup do container PostgreSQL
-	postgresContainer, db, err := setupPostgresContainer(ctx)
-	if err != nil {
-		t.Fatalf("Failed to setup PostgreSQL container: %v", err)
-	}
-	defer postgresContainer.Terminate(ctx)
-	defer db.Close()
-
-	// Dados de erro simulados
-	errorData := map[string]interface{}{
-		"video_id":  1,
-		"phases":    []string{"Phase 1", "Phase 2"},
-		"error_msg": "Test error", // Certifique-se de incluir "error_msg"
-	}
+	testRegisterError(t, setupPostgresStore(t))
+}
 
-	// Registrar o erro
-	converter.RegisterError(db, errorData, fmt.Errorf("Test error"))
+func TestPostgresMigrations(t *testing.T) {
+	ctx := context.Background()
+	store := setupPostgresStore(t)
 
-	// Verificar se o erro foi registrado corretamente no banco de dados
-	var errorDetails []byte
-	err = db.QueryRow("SELECT error_details FROM process_errors_log WHERE id = 1").Scan(&errorDetails)
+	// O setup já aplicou tudo, os scripts de down precisam desfazer cada versão
+	status, err := store.MigrationStatus(ctx)
 	assert.NoError(t, err)
-
-	// Verificar se os detalhes do erro estão corretos
-	var loggedError map[string]interface{}
-	err = json.Unmarshal(errorDetails, &loggedError)
+	reverted, err := store.MigrateDown(ctx, len(status))
 	assert.NoError(t, err)
+	assert.Equal(t, len(status), reverted)
 
-	assert.Equal(t, float64(1), loggedError["video_id"].(float64)) // A conversão de int para float64 é normal no JSON
-	assert.Equal(t, "Test error", loggedError["error_msg"])
+	applied, err := store.MigrateUp(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, len(status), applied)
 }
@@ -0,0 +1,323 @@
+package converter
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// JobState is the state of the conversion job of a video
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"    // Waiting for a worker, initially or before a retry
+	JobRunning   JobState = "running"   // Claimed by a worker
+	JobSucceeded JobState = "succeeded" // Converted, the confirmation was published
+	JobFailed    JobState = "failed"    // Abandoned after a permanent error or the last attempt
+	JobCancelled JobState = "cancelled" // Stopped by an operator, never claimed again until requeued
+)
+
+// jobTransitions lists, for each state, the states a job may come from. Succeeded and cancelled jobs are only
+// queued again by an operator, through Transition; the workers finish their jobs with MarkProcessed and ReleaseJob.
+var jobTransitions = map[JobState][]JobState{
+	JobQueued:    {JobRunning, JobSucceeded, JobFailed, JobCancelled},
+	JobRunning:   {JobQueued, JobFailed},
+	JobSucceeded: {JobRunning},
+	JobFailed:    {JobRunning},
+	JobCancelled: {JobQueued, JobRunning, JobFailed},
+}
+
+var (
+	// ErrJobNotFound is returned when the video has no conversion job
+	ErrJobNotFound = errors.New("conversion job not found")
+	// ErrInvalidTransition is returned when the job is not in a state that allows the transition
+	ErrInvalidTransition = errors.New("invalid job state transition")
+)
+
+// CanTransition reports whether a job may move from one state to another
+func CanTransition(from, to JobState) bool {
+	for _, state := range jobTransitions[to] {
+		if state == from {
+			return true
+		}
+	}
+	return false
+}
+
+// Job is the conversion job of a video
+type Job struct {
+	VideoID     int        `json:"video_id"`
+	Path        string     `json:"path"`
+	State       JobState   `json:"state"`
+	Attempts    int        `json:"attempts"`
+	WorkerID    string     `json:"worker_id,omitempty"`
+	LeaseExpiry *time.Time `json:"lease_expires_at,omitempty"`
+	Phase       Phase      `json:"phase,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	MergingAt   *time.Time `json:"merging_at,omitempty"`
+	ProbingAt   *time.Time `json:"probing_at,omitempty"`
+	EncodingAt  *time.Time `json:"encoding_at,omitempty"`
+	PackagingAt *time.Time `json:"packaging_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// PhaseTiming is how long a phase of the current attempt took, or has been running for
+type PhaseTiming struct {
+	Phase     Phase     `json:"phase"`
+	StartedAt time.Time `json:"started_at"`
+	Duration  float64   `json:"duration_seconds"`
+}
+
+// PhaseTimings lists the phases the current attempt went through, in order. Each phase lasts until the next
+// one started or the attempt ended, the phase still running is measured until now.
+func (j *Job) PhaseTimings(now time.Time) []PhaseTiming {
+	var timings []PhaseTiming
+	for _, phase := range []struct {
+		phase Phase
+		at    *time.Time
+	}{{PhaseMerging, j.MergingAt}, {PhaseProbing, j.ProbingAt}, {PhaseEncoding, j.EncodingAt}, {PhasePackaging, j.PackagingAt}} {
+		if phase.at == nil {
+			continue
+		}
+		if len(timings) > 0 {
+			last := &timings[len(timings)-1]
+			last.Duration = phase.at.Sub(last.StartedAt).Seconds()
+		}
+		timings = append(timings, PhaseTiming{Phase: phase.phase, StartedAt: *phase.at})
+	}
+	if len(timings) == 0 {
+		return nil
+	}
+
+	end := now
+	switch {
+	case j.FinishedAt != nil:
+		end = *j.FinishedAt
+	case j.State != JobRunning:
+		end = j.UpdatedAt
+	}
+	last := &timings[len(timings)-1]
+	last.Duration = end.Sub(last.StartedAt).Seconds()
+	return timings
+}
+
+// phaseColumns maps each phase to the column holding the time it started in the current attempt
+var phaseColumns = map[Phase]string{
+	PhaseMerging:   "merging_at",
+	PhaseProbing:   "probing_at",
+	PhaseEncoding:  "encoding_at",
+	PhasePackaging: "packaging_at",
+}
+
+const jobColumns = `video_id, COALESCE(path, ''), state, attempts, COALESCE(worker_id, ''), lease_expires_at, COALESCE(phase, ''),
+	COALESCE(last_error, ''), created_at, updated_at, started_at, merging_at, probing_at, encoding_at, packaging_at, finished_at`
+
+// scanJob reads a row selected with jobColumns
+func scanJob(row interface{ Scan(...any) error }) (*Job, error) {
+	var job Job
+	var lease, started, merging, probing, encoding, packaging, finished sql.NullTime
+	err := row.Scan(&job.VideoID, &job.Path, &job.State, &job.Attempts, &job.WorkerID, &lease, &job.Phase, &job.LastError,
+		&job.CreatedAt, &job.UpdatedAt, &started, &merging, &probing, &encoding, &packaging, &finished)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range []struct {
+		src sql.NullTime
+		dst **time.Time
+	}{{lease, &job.LeaseExpiry}, {started, &job.StartedAt}, {merging, &job.MergingAt}, {probing, &job.ProbingAt}, {encoding, &job.EncodingAt}, {packaging, &job.PackagingAt}, {finished, &job.FinishedAt}} {
+		if t.src.Valid {
+			value := t.src.Time
+			*t.dst = &value
+		}
+	}
+	return &job, nil
+}
+
+// statesSQL renders the states allowed to move into to as a SQL list, e.g. ('queued','failed')
+func statesSQL(to JobState) string {
+	quoted := make([]string, len(jobTransitions[to]))
+	for i, state := range jobTransitions[to] {
+		quoted[i] = "'" + string(state) + "'"
+	}
+	return "(" + strings.Join(quoted, ",") + ")"
+}
+
+// GetJob loads the conversion job of the video
+func (s *SQLStore) GetJob(videoID int) (*Job, error) {
+	job, err := scanJob(s.db.QueryRow("SELECT "+jobColumns+" FROM conversion_jobs WHERE video_id = $1", videoID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	return job, err
+}
+
+// ClaimJob atomically moves the job of the video to running on behalf of workerID, creating it when needed,
+// with a lease expiring after the given duration. Only queued and failed jobs, or running jobs whose lease has
+// expired, can be claimed, so a single worker runs a video at a time; a forced task also claims succeeded and
+// cancelled jobs to convert them again. When the job cannot be claimed it is returned as is with claimed set to false.
+func (s *SQLStore) ClaimJob(task VideoTask, workerID string, lease time.Duration) (job *Job, claimed bool, err error) {
+	now := s.now()
+	claimable := statesSQL(JobRunning)
+	if task.Force {
+		claimable = "('queued','failed','succeeded','cancelled')"
+	}
+
+	query := `INSERT INTO conversion_jobs (video_id, path, state, attempts, worker_id, lease_expires_at, created_at, updated_at, started_at)
+		VALUES ($1, $2, 'running', 1, $3, $4, $5, $5, $5)
+		ON CONFLICT (video_id) DO UPDATE SET
+			path = EXCLUDED.path, state = 'running', attempts = conversion_jobs.attempts + 1, worker_id = EXCLUDED.worker_id,
+			lease_expires_at = EXCLUDED.lease_expires_at, phase = NULL, last_error = NULL,
+			updated_at = EXCLUDED.updated_at, started_at = EXCLUDED.started_at,
+			merging_at = NULL, probing_at = NULL, encoding_at = NULL, packaging_at = NULL, finished_at = NULL
+		WHERE conversion_jobs.state IN ` + claimable + `
+			OR (conversion_jobs.state = 'running' AND conversion_jobs.lease_expires_at < EXCLUDED.updated_at)
+		RETURNING ` + jobColumns
+
+	job, err = scanJob(s.db.QueryRow(query, task.VideoID, task.Path, workerID, now.Add(lease), now))
+	if err == nil {
+		return job, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		slog.Error("Error claiming conversion job", slog.Int("video_id", task.VideoID), slog.String("error", err.Error()))
+		return nil, false, err
+	}
+
+	job, err = s.GetJob(task.VideoID)
+	return job, false, err
+}
+
+// JobFilter selects conversion jobs, zero fields match every job
+type JobFilter struct {
+	FromID int        // Lowest video ID
+	ToID   int        // Highest video ID
+	States []JobState // Any of these states
+	Limit  int        // Maximum number of jobs
+}
+
+// FindJobs lists the jobs matching the filter, ordered by video ID
+func (s *SQLStore) FindJobs(filter JobFilter) ([]Job, error) {
+	var conditions []string
+	var args []any
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.FromID > 0 {
+		where("video_id >= $%d", filter.FromID)
+	}
+	if filter.ToID > 0 {
+		where("video_id <= $%d", filter.ToID)
+	}
+	if len(filter.States) > 0 {
+		placeholders := make([]string, len(filter.States))
+		for i, state := range filter.States {
+			args = append(args, string(state))
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		conditions = append(conditions, "state IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	query := "SELECT " + jobColumns + " FROM conversion_jobs"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY video_id"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+// StartPhase records the phase the running job of the video has entered
+func (s *SQLStore) StartPhase(videoID int, phase Phase) error {
+	column, ok := phaseColumns[phase]
+	if !ok {
+		return fmt.Errorf("unknown phase %q", phase)
+	}
+
+	now := s.now()
+	query := fmt.Sprintf("UPDATE conversion_jobs SET phase = $1, %s = $2, updated_at = $2 WHERE video_id = $3 AND state = 'running'", column)
+	_, err := s.db.Exec(query, string(phase), now, videoID)
+	return err
+}
+
+// Transition moves the job of the video to the given state, failing with ErrInvalidTransition
+// when its current state does not allow it
+func (s *SQLStore) Transition(videoID int, to JobState, lastError string) error {
+	return s.transition(s.db, videoID, to, lastError)
+}
+
+// ReleaseJob moves the job of the video running on workerID to queued, waiting for a retry, or to failed.
+// It fails with ErrLeaseLost when the job no longer runs there, so a worker finishing late never moves back
+// a job that succeeded, was cancelled or was reclaimed by another worker in the meantime.
+func (s *SQLStore) ReleaseJob(videoID int, workerID string, to JobState, lastError string) error {
+	if to != JobQueued && to != JobFailed {
+		return fmt.Errorf("%w: video %d released to %s", ErrInvalidTransition, videoID, to)
+	}
+	now := s.now()
+	var finishedAt *time.Time
+	if to == JobFailed {
+		finishedAt = &now
+	}
+
+	query := `UPDATE conversion_jobs SET state = $1, last_error = $2, finished_at = $3, updated_at = $4, lease_expires_at = NULL
+		WHERE video_id = $5 AND state = 'running' AND worker_id = $6`
+	result, err := s.db.Exec(query, string(to), sql.NullString{String: lastError, Valid: lastError != ""}, finishedAt, now, videoID, workerID)
+	if err != nil {
+		slog.Error("Error releasing conversion job", slog.Int("video_id", videoID), slog.String("state", string(to)), slog.String("error", err.Error()))
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// execer runs a statement on the database or within a transaction
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func (s *SQLStore) transition(db execer, videoID int, to JobState, lastError string) error {
+	now := s.now()
+	var finishedAt *time.Time
+	if to == JobSucceeded || to == JobFailed || to == JobCancelled {
+		finishedAt = &now
+	}
+
+	query := `UPDATE conversion_jobs SET state = $1, last_error = $2, finished_at = $3, updated_at = $4, lease_expires_at = NULL
+		WHERE video_id = $5 AND state IN ` + statesSQL(to)
+	result, err := db.Exec(query, string(to), sql.NullString{String: lastError, Valid: lastError != ""}, finishedAt, now, videoID)
+	if err != nil {
+		slog.Error("Error updating conversion job", slog.Int("video_id", videoID), slog.String("state", string(to)), slog.String("error", err.Error()))
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("%w: video %d to %s", ErrInvalidTransition, videoID, to)
+	}
+	return nil
+}
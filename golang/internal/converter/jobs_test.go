@@ -0,0 +1,57 @@
+package converter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanTransition(t *testing.T) {
+	// Só jobs na fila ou que falharam podem ser assumidos
+	assert.True(t, CanTransition(JobQueued, JobRunning))
+	assert.True(t, CanTransition(JobFailed, JobRunning))
+	assert.False(t, CanTransition(JobRunning, JobRunning))
+	assert.False(t, CanTransition(JobSucceeded, JobRunning))
+	assert.False(t, CanTransition(JobCancelled, JobRunning))
+
+	// Only a running job finishes
+	assert.True(t, CanTransition(JobRunning, JobSucceeded))
+	assert.False(t, CanTransition(JobQueued, JobSucceeded))
+	assert.False(t, CanTransition(JobFailed, JobSucceeded))
+
+	// Every finished job can be queued again
+	for _, from := range []JobState{JobRunning, JobSucceeded, JobFailed, JobCancelled} {
+		assert.True(t, CanTransition(from, JobQueued), from)
+	}
+	assert.False(t, CanTransition(JobSucceeded, JobCancelled))
+}
+
+func TestStatesSQL(t *testing.T) {
+	assert.Equal(t, "('queued','failed')", statesSQL(JobRunning))
+	assert.Equal(t, "('running')", statesSQL(JobSucceeded))
+}
+
+func TestPhaseTimings(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	at := func(seconds int) *time.Time {
+		t := start.Add(time.Duration(seconds) * time.Second)
+		return &t
+	}
+
+	// A fase em andamento é medida até agora
+	job := Job{State: JobRunning, MergingAt: at(0), ProbingAt: at(5), EncodingAt: at(7)}
+	assert.Equal(t, []PhaseTiming{
+		{Phase: PhaseMerging, StartedAt: *at(0), Duration: 5},
+		{Phase: PhaseProbing, StartedAt: *at(5), Duration: 2},
+		{Phase: PhaseEncoding, StartedAt: *at(7), Duration: 93},
+	}, job.PhaseTimings(*at(100)))
+
+	// A finished attempt ends its last phase at finished_at
+	job = Job{State: JobFailed, MergingAt: at(0), ProbingAt: at(5), FinishedAt: at(6)}
+	timings := job.PhaseTimings(*at(100))
+	assert.Len(t, timings, 2)
+	assert.Equal(t, float64(1), timings[1].Duration)
+
+	assert.Empty(t, (&Job{State: JobQueued}).PhaseTimings(*at(100)))
+}
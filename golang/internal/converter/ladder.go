@@ -0,0 +1,112 @@
+package converter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Rendition describes a single rung of the adaptive bitrate ladder
+type Rendition struct {
+	Name          string
+	Width         int
+	Height        int
+	VideoKbps     int
+	AudioKbps     int
+	AudioChannels int
+}
+
+// Ladder is the list of renditions encoded for each video, ordered from the highest to the lowest quality
+type Ladder []Rendition
+
+// DefaultLadder returns the ladder used when none is configured
+func DefaultLadder() Ladder {
+	return Ladder{
+		{Name: "1080p", Width: 1920, Height: 1080, VideoKbps: 5000, AudioKbps: 192, AudioChannels: 2},
+		{Name: "720p", Width: 1280, Height: 720, VideoKbps: 2800, AudioKbps: 128, AudioChannels: 2},
+		{Name: "480p", Width: 854, Height: 480, VideoKbps: 1400, AudioKbps: 128, AudioChannels: 2},
+		{Name: "360p", Width: 640, Height: 360, VideoKbps: 800, AudioKbps: 96, AudioChannels: 2},
+	}
+}
+
+// ParseLadder parses a comma separated list of renditions in the format
+// name:WIDTHxHEIGHT:videoBitrate:audioBitrate[:audioChannels], e.g. "720p:1280x720:2800k:128k:2"
+func ParseLadder(spec string) (Ladder, error) {
+	var ladder Ladder
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		fields := strings.Split(entry, ":")
+		if len(fields) != 4 && len(fields) != 5 {
+			return nil, fmt.Errorf("invalid rendition %q: expected name:WIDTHxHEIGHT:videoBitrate:audioBitrate[:audioChannels]", entry)
+		}
+
+		r := Rendition{Name: fields[0], AudioChannels: 2}
+		if _, err := fmt.Sscanf(fields[1], "%dx%d", &r.Width, &r.Height); err != nil || r.Width <= 0 || r.Height <= 0 {
+			return nil, fmt.Errorf("invalid resolution %q in rendition %q", fields[1], entry)
+		}
+
+		var err error
+		if r.VideoKbps, err = parseKbps(fields[2]); err != nil {
+			return nil, fmt.Errorf("invalid video bitrate in rendition %q: %v", entry, err)
+		}
+		if r.AudioKbps, err = parseKbps(fields[3]); err != nil {
+			return nil, fmt.Errorf("invalid audio bitrate in rendition %q: %v", entry, err)
+		}
+		if len(fields) == 5 {
+			if r.AudioChannels, err = strconv.Atoi(fields[4]); err != nil || r.AudioChannels <= 0 {
+				return nil, fmt.Errorf("invalid audio channels %q in rendition %q", fields[4], entry)
+			}
+		}
+
+		ladder = append(ladder, r)
+	}
+
+	if len(ladder) == 0 {
+		return nil, fmt.Errorf("ladder must contain at least one rendition")
+	}
+	return ladder, nil
+}
+
+// ForSource returns the renditions that do not upscale a source with the given resolution.
+// When the source is smaller than every rung, the lowest rung is kept at the source resolution, rounded
+// down to even dimensions since yuv420p cannot encode odd ones.
+func (l Ladder) ForSource(width, height int) Ladder {
+	var renditions Ladder
+	for _, r := range l {
+		if r.Height <= height {
+			renditions = append(renditions, r)
+		}
+	}
+	if len(renditions) > 0 || len(l) == 0 {
+		return renditions
+	}
+
+	lowest := l[len(l)-1]
+	lowest.Width = max(width&^1, 2)
+	lowest.Height = max(height&^1, 2)
+	lowest.Name = fmt.Sprintf("%dp", lowest.Height)
+	return Ladder{lowest}
+}
+
+// parseKbps converts a bitrate such as "2800k", "2.5M" or "800" into kilobits per second
+func parseKbps(value string) (int, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(value, "k"):
+		value = strings.TrimSuffix(value, "k")
+	case strings.HasSuffix(value, "m"):
+		value = strings.TrimSuffix(value, "m")
+		multiplier = 1000
+	}
+
+	kbps, err := strconv.ParseFloat(value, 64)
+	if err != nil || kbps <= 0 {
+		return 0, fmt.Errorf("invalid bitrate %q", value)
+	}
+	return int(kbps * multiplier), nil
+}
@@ -0,0 +1,48 @@
+package converter_test
+
+import (
+	"imersaofc/internal/converter"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLadder(t *testing.T) {
+	ladder, err := converter.ParseLadder("720p:1280x720:2800k:128k, 360p:640x360:0.8M:96k:1")
+	assert.NoError(t, err)
+	assert.Equal(t, converter.Ladder{
+		{Name: "720p", Width: 1280, Height: 720, VideoKbps: 2800, AudioKbps: 128, AudioChannels: 2},
+		{Name: "360p", Width: 640, Height: 360, VideoKbps: 800, AudioKbps: 96, AudioChannels: 1},
+	}, ladder)
+
+	// Entradas inválidas devem retornar erro
+	for _, spec := range []string{"", "720p:1280x720:2800k", "720p:1280:2800k:128k", "720p:1280x720:fast:128k", "720p:1280x720:2800k:128k:0"} {
+		_, err := converter.ParseLadder(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestLadderForSource(t *testing.T) {
+	ladder := converter.DefaultLadder()
+
+	// Rungs above the source resolution are skipped
+	renditions := ladder.ForSource(1280, 720)
+	assert.Len(t, renditions, 3)
+	assert.Equal(t, "720p", renditions[0].Name)
+
+	// A full HD source keeps every rung
+	assert.Len(t, ladder.ForSource(1920, 1080), len(ladder))
+
+	// A source smaller than every rung is encoded once at its own resolution
+	renditions = ladder.ForSource(426, 240)
+	assert.Len(t, renditions, 1)
+	assert.Equal(t, "240p", renditions[0].Name)
+	assert.Equal(t, 240, renditions[0].Height)
+
+	// Odd dimensions are rounded down, libx264 refuses them in yuv420p
+	renditions = ladder.ForSource(427, 241)
+	assert.Len(t, renditions, 1)
+	assert.Equal(t, "240p", renditions[0].Name)
+	assert.Equal(t, 426, renditions[0].Width)
+	assert.Equal(t, 240, renditions[0].Height)
+}
@@ -0,0 +1,124 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"imersaofc/pkg/contracts"
+)
+
+// defaultLeaseDuration is how long a claimed job belongs to its worker without a heartbeat
+const defaultLeaseDuration = 60 * time.Second
+
+// ErrLeaseLost is returned when the job no longer runs on the worker, e.g. it was reclaimed by another
+// worker after the lease expired or cancelled by an operator
+var ErrLeaseLost = errors.New("conversion job lease lost")
+
+// ErrJobCancelled is the cause of a conversion stopped because an operator cancelled its job
+var ErrJobCancelled = errors.New("conversion job cancelled")
+
+// RenewLease extends the lease of the job running on workerID until now plus lease
+func (s *SQLStore) RenewLease(videoID int, workerID string, lease time.Duration) error {
+	now := s.now()
+	query := `UPDATE conversion_jobs SET lease_expires_at = $1, updated_at = $2
+		WHERE video_id = $3 AND state = 'running' AND worker_id = $4`
+	result, err := s.db.Exec(query, now.Add(lease), now, videoID, workerID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// RequeueExpiredJobs moves the running jobs whose lease expired before now back to queued and queues a new
+// conversion message for each one in the outbox, in the same transaction, to be published by the OutboxRelay
+// through exchange and routingKey. It returns how many jobs were requeued.
+func (s *SQLStore) RequeueExpiredJobs(now time.Time, exchange, routingKey, queue string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE conversion_jobs SET state = 'queued', last_error = 'lease expired', lease_expires_at = NULL, updated_at = $1
+		WHERE state = 'running' AND lease_expires_at < $1
+		RETURNING video_id, COALESCE(path, '')`
+	rows, err := tx.Query(query, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	var tasks []VideoTask
+	for rows.Next() {
+		var task VideoTask
+		if err := rows.Scan(&task.VideoID, &task.Path); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		tasks = append(tasks, task)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// A publicação fica para o relay, assim a transação não espera pelo broker
+	for _, task := range tasks {
+		payload, err := contracts.Marshal(contracts.ConversionRequestedV1, task)
+		if err != nil {
+			return 0, err
+		}
+		message := newOutboxMessage(context.Background(), task.VideoID, exchange, routingKey, queue, contracts.ConversionRequestedV1, payload)
+		if err := s.enqueue(tx, message); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	for _, task := range tasks {
+		slog.Warn("Requeued conversion job with expired lease", slog.Int("video_id", task.VideoID))
+	}
+	return len(tasks), nil
+}
+
+// keepLease renews the lease of the job every third of its duration until ctx is done.
+// When the job no longer runs on the worker the conversion is canceled with ErrJobCancelled if an operator
+// cancelled it, ErrLeaseLost if it was taken over by another worker.
+func (vc *VideoConverter) keepLease(ctx context.Context, videoID int, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(vc.leaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := vc.store.RenewLease(videoID, vc.workerID, vc.leaseDuration)
+			if errors.Is(err, ErrLeaseLost) {
+				cause := vc.leaseLostCause(videoID)
+				slog.Error("Conversion job no longer running, stopping", slog.Int("video_id", videoID), slog.String("cause", cause.Error()))
+				cancel(cause)
+				return
+			}
+			if err != nil {
+				// O lease ainda vale até expirar, tenta de novo no próximo heartbeat
+				slog.Warn("Failed to renew conversion job lease", slog.Int("video_id", videoID), slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// leaseLostCause tells why the job no longer runs on the worker from the state it moved to: ErrJobCancelled
+// when an operator cancelled it, otherwise ErrLeaseLost
+func (vc *VideoConverter) leaseLostCause(videoID int) error {
+	job, err := vc.store.GetJob(videoID)
+	if err == nil && job.State == JobCancelled {
+		return ErrJobCancelled
+	}
+	return ErrLeaseLost
+}
@@ -0,0 +1,56 @@
+package converter_test
+
+import (
+	"errors"
+	"imersaofc/internal/converter"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const requeueExpiredQuery = `UPDATE conversion_jobs SET state = 'queued'`
+
+func TestRequeueExpiredJobs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// As mensagens vão para o outbox na mesma transação, o relay publica depois do commit
+	now := time.Now().UTC()
+	mock.ExpectBegin()
+	mock.ExpectQuery(requeueExpiredQuery).WithArgs(now).
+		WillReturnRows(sqlmock.NewRows([]string{"video_id", "path"}).AddRow(1, "/media/uploads/1").AddRow(2, "/media/uploads/2"))
+	mock.ExpectExec(enqueueOutboxQuery).
+		WithArgs(1, "conversion_exchange", "conversion", "video_conversion_queue", `{"video_id":1,"path":"/media/uploads/1"}`, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(enqueueOutboxQuery).
+		WithArgs(2, "conversion_exchange", "conversion", "video_conversion_queue", `{"video_id":2,"path":"/media/uploads/2"}`, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	count, err := converter.NewPostgresStore(db).RequeueExpiredJobs(now, "conversion_exchange", "conversion", "video_conversion_queue")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRequeueExpiredJobsRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Se o outbox falha os jobs continuam expirados para a próxima rodada
+	now := time.Now().UTC()
+	mock.ExpectBegin()
+	mock.ExpectQuery(requeueExpiredQuery).WithArgs(now).
+		WillReturnRows(sqlmock.NewRows([]string{"video_id", "path"}).AddRow(1, "/media/uploads/1"))
+	mock.ExpectExec(enqueueOutboxQuery).WillReturnError(errors.New("disk full"))
+	mock.ExpectRollback()
+
+	count, err := converter.NewPostgresStore(db).RequeueExpiredJobs(now, "conversion_exchange", "conversion", "video_conversion_queue")
+	assert.Error(t, err)
+	assert.Zero(t, count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
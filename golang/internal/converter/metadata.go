@@ -0,0 +1,24 @@
+package converter
+
+import (
+	"log/slog"
+)
+
+// SaveMetadata stores the probed media info of the video, replacing any previous probe
+func (s *SQLStore) SaveMetadata(videoID int, info *MediaInfo) error {
+	query := `INSERT INTO video_metadata
+		(video_id, duration_seconds, container, video_codec, audio_codec, width, height, frame_rate, audio_channels, rotation, probed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (video_id) DO UPDATE SET
+			duration_seconds = EXCLUDED.duration_seconds, container = EXCLUDED.container,
+			video_codec = EXCLUDED.video_codec, audio_codec = EXCLUDED.audio_codec,
+			width = EXCLUDED.width, height = EXCLUDED.height, frame_rate = EXCLUDED.frame_rate,
+			audio_channels = EXCLUDED.audio_channels, rotation = EXCLUDED.rotation, probed_at = EXCLUDED.probed_at`
+	_, err := s.db.Exec(query, videoID, info.Duration, info.Container, info.VideoCodec, info.AudioCodec,
+		info.Width, info.Height, info.FrameRate, info.AudioChannels, info.Rotation, s.now())
+	if err != nil {
+		slog.Error("Error storing video metadata", slog.Int("video_id", videoID), slog.String("error", err.Error()))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package converter
+
+import (
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/streadway/amqp"
+)
+
+// Steps of the conversion timed by stepDuration
+const (
+	stepMerge      = "merge"
+	stepProbe      = "probe"
+	stepEncode     = "encode"
+	stepThumbnails = "thumbnails"
+)
+
+// Metrics of the conversion pipeline, registered in the default Prometheus registry
+var (
+	messagesReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "videoconverter",
+		Name:      "messages_received_total",
+		Help:      "Conversion messages received.",
+	})
+	messagesAcked = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "videoconverter",
+		Name:      "messages_acked_total",
+		Help:      "Conversion messages acked without a retry, converted or skipped.",
+	})
+	messagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "videoconverter",
+		Name:      "messages_failed_total",
+		Help:      "Failed conversion attempts, by error code.",
+	}, []string{"reason"})
+	stepDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "videoconverter",
+		Name:      "step_duration_seconds",
+		Help:      "Duration of each step of a conversion: merge, probe, encode and thumbnails.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 20),
+	}, []string{"step"})
+	outputBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "videoconverter",
+		Name:      "output_bytes",
+		Help:      "Size of the MPEG-DASH and HLS output of a conversion.",
+		Buckets:   prometheus.ExponentialBuckets(1<<20, 2, 16),
+	})
+	encodeSpeed = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "videoconverter",
+		Name:      "encode_speed_ratio",
+		Help:      "Seconds of video encoded per second, 1 being realtime.",
+		Buckets:   prometheus.ExponentialBuckets(0.125, 2, 11),
+	})
+	jobsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "videoconverter",
+		Name:      "jobs_in_flight",
+		Help:      "Conversions running on this worker.",
+	})
+	outboxRelayed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "videoconverter",
+		Name:      "outbox_relayed_total",
+		Help:      "Outbox messages relayed to RabbitMQ, by outcome (sent or failed).",
+	}, []string{"outcome"})
+)
+
+// observeStep records the duration of a step started at start
+func observeStep(step string, start time.Time) {
+	stepDuration.WithLabelValues(step).Observe(time.Since(start).Seconds())
+}
+
+// ack acknowledges a delivery that needs no further attempt
+func ack(d amqp.Delivery) {
+	d.Ack(false)
+	messagesAcked.Inc()
+}
+
+// dirSize sums the size of the files under dir
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,98 @@
+package converter_test
+
+import (
+	"errors"
+	"imersaofc/internal/converter"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// metricValue reads a counter or gauge, or the sample count of a histogram, from the default registry
+func metricValue(t *testing.T, name string, labels map[string]string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if value, ok := labels[label.GetName()]; ok && value != label.GetValue() {
+					continue metrics
+				}
+			}
+			switch {
+			case metric.Counter != nil:
+				return metric.Counter.GetValue()
+			case metric.Gauge != nil:
+				return metric.Gauge.GetValue()
+			case metric.Histogram != nil:
+				return float64(metric.Histogram.GetSampleCount())
+			}
+		}
+	}
+	return 0
+}
+
+func TestHandleMessageRecordsMetrics(t *testing.T) {
+	names := []struct {
+		name   string
+		labels map[string]string
+	}{
+		{"videoconverter_messages_received_total", nil},
+		{"videoconverter_messages_acked_total", nil},
+		{"videoconverter_messages_failed_total", map[string]string{"reason": "encode_failed"}},
+		{"videoconverter_step_duration_seconds", map[string]string{"step": "merge"}},
+		{"videoconverter_step_duration_seconds", map[string]string{"step": "encode"}},
+		{"videoconverter_step_duration_seconds", map[string]string{"step": "thumbnails"}},
+		{"videoconverter_output_bytes", nil},
+		{"videoconverter_encode_speed_ratio", nil},
+		{"videoconverter_jobs_in_flight", nil},
+	}
+	snapshot := func() []float64 {
+		values := make([]float64, len(names))
+		for i, metric := range names {
+			values[i] = metricValue(t, metric.name, metric.labels)
+		}
+		return values
+	}
+	delta := func(before []float64) []float64 {
+		after := snapshot()
+		for i := range after {
+			after[i] -= before[i]
+		}
+		return after
+	}
+
+	// Uma conversão completa passa por todas as etapas
+	f := newHandlerFixture(t)
+	writeChunks(t, f.rootPath, 1)
+	expectClaim(f.mock, 1)
+	expectPhases(f.mock, 1, converter.PhaseMerging, converter.PhaseProbing)
+	f.mock.ExpectExec(saveMetadataQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPhases(f.mock, 1, converter.PhaseEncoding, converter.PhasePackaging)
+	expectMarkProcessed(f.mock, 1)
+
+	before := snapshot()
+	f.handle(t, converter.VideoTask{VideoID: 1, Path: "/media/uploads/1"})
+	assert.Equal(t, []float64{1, 1, 0, 1, 1, 1, 1, 1, 0}, delta(before))
+
+	// A failed encoding is counted by its code and not acked as done
+	f = newHandlerFixture(t)
+	f.transcoder.TranscodeErr = errors.New("exit status 1")
+	writeChunks(t, f.rootPath, 2)
+	expectClaim(f.mock, 2)
+	expectPhases(f.mock, 2, converter.PhaseMerging, converter.PhaseProbing)
+	f.mock.ExpectExec(saveMetadataQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPhases(f.mock, 2, converter.PhaseEncoding)
+	f.mock.ExpectExec(registerErrorQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectTransition(f.mock, 2, converter.JobQueued)
+
+	before = snapshot()
+	f.handle(t, converter.VideoTask{VideoID: 2, Path: "/media/uploads/2"})
+	assert.Equal(t, []float64{1, 0, 1, 1, 1, 0, 0, 0, 0}, delta(before))
+}
@@ -0,0 +1,115 @@
+package converter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	postgres, err := loadMigrations(DriverPostgres)
+	assert.NoError(t, err)
+	sqlite, err := loadMigrations(DriverSQLite)
+	assert.NoError(t, err)
+
+	// Os dois bancos têm as mesmas versões, em ordem e sem lacunas
+	assert.Len(t, sqlite, len(postgres))
+	for i, migration := range postgres {
+		assert.Equal(t, i+1, migration.Version)
+		assert.Equal(t, migration.Name, sqlite[i].Name)
+		assert.NotEmpty(t, migration.Up)
+		assert.NotEmpty(t, migration.Down)
+	}
+
+	_, err = loadMigrations("mysql")
+	assert.Error(t, err)
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenSQLiteStore(":memory:")
+	assert.NoError(t, err)
+	defer store.Close()
+
+	// Opening the store leaves the migrations to MigrateUp
+	applied, err := store.MigrateUp(ctx)
+	assert.NoError(t, err)
+	status, err := store.MigrationStatus(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, len(status), applied)
+	for _, migration := range status {
+		assert.NotNil(t, migration.AppliedAt, migration.Name)
+	}
+	applied, err = store.MigrateUp(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, applied)
+
+	// Reverting down to conversion_jobs keeps the converted videos in processed_videos
+	_, claimed, err := store.ClaimJob(VideoTask{VideoID: 1, Path: "/media/uploads/1"}, "worker-1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	assert.NoError(t, store.MarkProcessed(1, "worker-1"))
+
+	steps := len(status) - 2
+	reverted, err := store.MigrateDown(ctx, steps)
+	assert.NoError(t, err)
+	assert.Equal(t, steps, reverted)
+	var count int
+	assert.NoError(t, store.db.QueryRow("SELECT COUNT(*) FROM processed_videos WHERE status = 'success'").Scan(&count))
+	assert.Equal(t, 1, count)
+
+	status, err = store.MigrationStatus(ctx)
+	assert.NoError(t, err)
+	assert.NotNil(t, status[1].AppliedAt)
+	assert.Nil(t, status[2].AppliedAt)
+
+	// E volta para conversion_jobs ao reaplicar
+	applied, err = store.MigrateUp(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, steps, applied)
+	job, err := store.GetJob(1)
+	assert.NoError(t, err)
+	assert.Equal(t, JobSucceeded, job.State)
+
+	// Down all the way leaves only schema_migrations
+	reverted, err = store.MigrateDown(ctx, len(status))
+	assert.NoError(t, err)
+	assert.Equal(t, len(status), reverted)
+	assert.NoError(t, store.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name NOT IN ('schema_migrations', 'sqlite_sequence')").Scan(&count))
+	assert.Zero(t, count)
+}
+
+func TestMigrateErrorLogBackfill(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenSQLiteStore(":memory:")
+	assert.NoError(t, err)
+	defer store.Close()
+	_, err = store.MigrateUp(ctx)
+	assert.NoError(t, err)
+
+	// Erros gravados antes das colunas só tinham o JSON, desfaz até antes da 0004
+	_, err = store.MigrateDown(ctx, 2)
+	assert.NoError(t, err)
+	_, err = store.db.Exec("INSERT INTO process_errors_log (error_details, created_at) VALUES ($1, $2), ($3, $2)",
+		`{"video_id": 7, "phase": "encoding", "code": "encode_failed", "error": "Error during video conversion", "details": "exit status 1", "attempt": 2, "retryable": true}`,
+		time.Now().UTC(), `{"video_id": "abc", "error_msg": "Test error"}`)
+	assert.NoError(t, err)
+
+	_, err = store.MigrateUp(ctx)
+	assert.NoError(t, err)
+
+	records, err := store.ErrorsByVideo(7)
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, PhaseEncoding, records[0].Phase)
+	assert.Equal(t, CodeEncodeFailed, records[0].Code)
+	assert.Equal(t, 2, records[0].Attempt)
+	assert.True(t, records[0].Retryable)
+	assert.Equal(t, "exit status 1", records[0].Detail)
+
+	counts, err := store.CountErrorsByCode(time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[ErrorCode]int{CodeEncodeFailed: 1, CodeUnknown: 1}, counts)
+}
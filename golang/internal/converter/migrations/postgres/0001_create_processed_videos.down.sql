@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS process_errors_log;
+DROP TABLE IF EXISTS processed_videos;
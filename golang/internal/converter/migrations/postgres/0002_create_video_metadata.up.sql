@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS video_metadata (
+    video_id INT PRIMARY KEY,
+    duration_seconds DOUBLE PRECISION NOT NULL,
+    container VARCHAR(100) NOT NULL,
+    video_codec VARCHAR(50) NOT NULL,
+    audio_codec VARCHAR(50) NOT NULL,
+    width INT NOT NULL,
+    height INT NOT NULL,
+    frame_rate DOUBLE PRECISION NOT NULL,
+    audio_channels INT NOT NULL,
+    rotation INT NOT NULL,
+    probed_at TIMESTAMP NOT NULL
+);
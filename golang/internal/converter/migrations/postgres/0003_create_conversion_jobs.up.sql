@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS conversion_jobs (
+    video_id INT PRIMARY KEY,
+    path VARCHAR(255),
+    state VARCHAR(20) NOT NULL CHECK (state IN ('queued', 'running', 'succeeded', 'failed', 'cancelled')),
+    attempts INT NOT NULL DEFAULT 0,
+    worker_id VARCHAR(255),
+    lease_expires_at TIMESTAMP,
+    phase VARCHAR(20),
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    started_at TIMESTAMP,
+    merging_at TIMESTAMP,
+    probing_at TIMESTAMP,
+    encoding_at TIMESTAMP,
+    packaging_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS conversion_jobs_state_idx ON conversion_jobs (state);
+CREATE INDEX IF NOT EXISTS conversion_jobs_lease_idx ON conversion_jobs (lease_expires_at) WHERE state = 'running';
+
+-- Os vídeos já convertidos continuam sendo ignorados
+INSERT INTO conversion_jobs (video_id, state, attempts, created_at, updated_at, finished_at)
+SELECT video_id, 'succeeded', 1, processed_at, processed_at, processed_at FROM processed_videos WHERE status = 'success'
+ON CONFLICT (video_id) DO NOTHING;
+
+DROP TABLE processed_videos;
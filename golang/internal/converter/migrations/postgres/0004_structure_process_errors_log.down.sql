@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS process_errors_log_code_idx;
+DROP INDEX IF EXISTS process_errors_log_phase_idx;
+DROP INDEX IF EXISTS process_errors_log_video_idx;
+
+ALTER TABLE process_errors_log
+    DROP COLUMN stderr,
+    DROP COLUMN detail,
+    DROP COLUMN message,
+    DROP COLUMN retryable,
+    DROP COLUMN attempt,
+    DROP COLUMN code,
+    DROP COLUMN phase,
+    DROP COLUMN video_id;
@@ -0,0 +1,23 @@
+ALTER TABLE process_errors_log
+    ADD COLUMN video_id INT,
+    ADD COLUMN phase VARCHAR(20),
+    ADD COLUMN code VARCHAR(50) NOT NULL DEFAULT 'unknown',
+    ADD COLUMN attempt INT NOT NULL DEFAULT 0,
+    ADD COLUMN retryable BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN message TEXT,
+    ADD COLUMN detail TEXT,
+    ADD COLUMN stderr TEXT;
+
+-- Preenche as colunas a partir do JSON dos erros registrados antes
+UPDATE process_errors_log SET
+    video_id = CASE WHEN jsonb_typeof(error_details->'video_id') = 'number' THEN (error_details->>'video_id')::numeric::int END,
+    phase = NULLIF(error_details->>'phase', ''),
+    code = COALESCE(NULLIF(error_details->>'code', ''), 'unknown'),
+    attempt = CASE WHEN jsonb_typeof(error_details->'attempt') = 'number' THEN (error_details->>'attempt')::numeric::int ELSE 0 END,
+    retryable = CASE WHEN jsonb_typeof(error_details->'retryable') = 'boolean' THEN (error_details->>'retryable')::boolean ELSE FALSE END,
+    message = error_details->>'error',
+    detail = error_details->>'details';
+
+CREATE INDEX process_errors_log_video_idx ON process_errors_log (video_id, created_at);
+CREATE INDEX process_errors_log_phase_idx ON process_errors_log (phase, created_at);
+CREATE INDEX process_errors_log_code_idx ON process_errors_log (code, created_at);
@@ -0,0 +1,11 @@
+CREATE TABLE processed_videos (
+    video_id INTEGER PRIMARY KEY,
+    status TEXT NOT NULL,
+    processed_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE process_errors_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    error_details TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
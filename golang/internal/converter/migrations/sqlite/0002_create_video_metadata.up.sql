@@ -0,0 +1,13 @@
+CREATE TABLE video_metadata (
+    video_id INTEGER PRIMARY KEY,
+    duration_seconds REAL NOT NULL,
+    container TEXT NOT NULL,
+    video_codec TEXT NOT NULL,
+    audio_codec TEXT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    frame_rate REAL NOT NULL,
+    audio_channels INTEGER NOT NULL,
+    rotation INTEGER NOT NULL,
+    probed_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,10 @@
+CREATE TABLE processed_videos (
+    video_id INTEGER PRIMARY KEY,
+    status TEXT NOT NULL,
+    processed_at TIMESTAMP NOT NULL
+);
+
+INSERT INTO processed_videos (video_id, status, processed_at)
+SELECT video_id, 'success', COALESCE(finished_at, updated_at) FROM conversion_jobs WHERE state = 'succeeded';
+
+DROP TABLE conversion_jobs;
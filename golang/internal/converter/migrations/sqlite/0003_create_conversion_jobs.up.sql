@@ -0,0 +1,25 @@
+CREATE TABLE conversion_jobs (
+    video_id INTEGER PRIMARY KEY,
+    path TEXT,
+    state TEXT NOT NULL CHECK (state IN ('queued', 'running', 'succeeded', 'failed', 'cancelled')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    worker_id TEXT,
+    lease_expires_at TIMESTAMP,
+    phase TEXT,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    started_at TIMESTAMP,
+    merging_at TIMESTAMP,
+    probing_at TIMESTAMP,
+    encoding_at TIMESTAMP,
+    packaging_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX conversion_jobs_state_idx ON conversion_jobs (state);
+
+INSERT INTO conversion_jobs (video_id, state, attempts, created_at, updated_at, finished_at)
+SELECT video_id, 'succeeded', 1, processed_at, processed_at, processed_at FROM processed_videos WHERE status = 'success';
+
+DROP TABLE processed_videos;
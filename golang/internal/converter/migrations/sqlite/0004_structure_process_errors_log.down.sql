@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS process_errors_log_code_idx;
+DROP INDEX IF EXISTS process_errors_log_phase_idx;
+DROP INDEX IF EXISTS process_errors_log_video_idx;
+
+ALTER TABLE process_errors_log DROP COLUMN stderr;
+ALTER TABLE process_errors_log DROP COLUMN detail;
+ALTER TABLE process_errors_log DROP COLUMN message;
+ALTER TABLE process_errors_log DROP COLUMN retryable;
+ALTER TABLE process_errors_log DROP COLUMN attempt;
+ALTER TABLE process_errors_log DROP COLUMN code;
+ALTER TABLE process_errors_log DROP COLUMN phase;
+ALTER TABLE process_errors_log DROP COLUMN video_id;
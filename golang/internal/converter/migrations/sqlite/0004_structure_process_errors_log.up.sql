@@ -0,0 +1,23 @@
+ALTER TABLE process_errors_log ADD COLUMN video_id INTEGER;
+ALTER TABLE process_errors_log ADD COLUMN phase TEXT;
+ALTER TABLE process_errors_log ADD COLUMN code TEXT NOT NULL DEFAULT 'unknown';
+ALTER TABLE process_errors_log ADD COLUMN attempt INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE process_errors_log ADD COLUMN retryable BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE process_errors_log ADD COLUMN message TEXT;
+ALTER TABLE process_errors_log ADD COLUMN detail TEXT;
+ALTER TABLE process_errors_log ADD COLUMN stderr TEXT;
+
+-- Preenche as colunas a partir do JSON dos erros registrados antes
+UPDATE process_errors_log SET
+    video_id = CASE WHEN json_type(error_details, '$.video_id') IN ('integer', 'real') THEN CAST(json_extract(error_details, '$.video_id') AS INTEGER) END,
+    phase = NULLIF(json_extract(error_details, '$.phase'), ''),
+    code = COALESCE(NULLIF(json_extract(error_details, '$.code'), ''), 'unknown'),
+    attempt = CASE WHEN json_type(error_details, '$.attempt') IN ('integer', 'real') THEN CAST(json_extract(error_details, '$.attempt') AS INTEGER) ELSE 0 END,
+    retryable = COALESCE(json_type(error_details, '$.retryable') = 'true', FALSE),
+    message = json_extract(error_details, '$.error'),
+    detail = json_extract(error_details, '$.details')
+WHERE json_valid(error_details);
+
+CREATE INDEX process_errors_log_video_idx ON process_errors_log (video_id, created_at);
+CREATE INDEX process_errors_log_phase_idx ON process_errors_log (phase, created_at);
+CREATE INDEX process_errors_log_code_idx ON process_errors_log (code, created_at);
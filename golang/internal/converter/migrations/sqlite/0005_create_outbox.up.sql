@@ -0,0 +1,17 @@
+-- Mensagens gravadas na mesma transação que a mudança de estado do job e publicadas pelo relay
+CREATE TABLE outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    video_id INTEGER,
+    exchange TEXT NOT NULL,
+    routing_key TEXT NOT NULL,
+    queue_name TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    headers TEXT,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    locked_until TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    sent_at TIMESTAMP
+);
+
+CREATE INDEX outbox_pending_idx ON outbox (id) WHERE sent_at IS NULL;
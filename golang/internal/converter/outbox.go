@@ -0,0 +1,218 @@
+package converter
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"imersaofc/pkg/contracts"
+	"imersaofc/pkg/rabbitmq"
+
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Defaults of the OutboxRelay
+const (
+	defaultOutboxInterval = 5 * time.Second
+	outboxLease           = 30 * time.Second // Wait before a claimed message is attempted again
+	outboxBatchSize       = 100
+)
+
+// OutboxMessage is a message queued in the outbox, waiting to be published by the OutboxRelay
+type OutboxMessage struct {
+	ID         int64             `json:"id"`
+	VideoID    int               `json:"video_id"`
+	Exchange   string            `json:"exchange"`
+	RoutingKey string            `json:"routing_key"`
+	Queue      string            `json:"queue"`
+	Payload    []byte            `json:"payload"`
+	Headers    map[string]string `json:"headers,omitempty"` // Contract of the message and trace context of the transaction that queued it
+	Attempts   int               `json:"attempts"`
+	LastError  string            `json:"last_error,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+// newOutboxMessage builds an outbox message of the contract carrying the trace context of ctx
+func newOutboxMessage(ctx context.Context, videoID int, exchange, routingKey, queue string, contract contracts.Contract, payload []byte) OutboxMessage {
+	headers := map[string]string{contracts.TypeHeader: contract.Type, contracts.VersionHeader: strconv.Itoa(contract.Version)}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(headers))
+	return OutboxMessage{VideoID: videoID, Exchange: exchange, RoutingKey: routingKey, Queue: queue, Payload: payload, Headers: headers}
+}
+
+// OutboxStore persists the messages of the outbox
+type OutboxStore interface {
+	// ClaimOutbox locks up to limit pending messages for lease, oldest first, counting an attempt for each
+	ClaimOutbox(now time.Time, lease time.Duration, limit int) ([]OutboxMessage, error)
+	// MarkOutboxSent registers that the message was confirmed by the broker
+	MarkOutboxSent(id int64) error
+	// RecordOutboxError stores why the message could not be published, it is attempted again once its lock expires
+	RecordOutboxError(id int64, lastError string) error
+}
+
+// enqueue inserts the message in the outbox within the transaction of a state change
+func (s *SQLStore) enqueue(db execer, message OutboxMessage) error {
+	headers, err := json.Marshal(message.Headers)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO outbox (video_id, exchange, routing_key, queue_name, payload, headers, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = db.Exec(query, message.VideoID, message.Exchange, message.RoutingKey, message.Queue, string(message.Payload), string(headers), s.now())
+	return err
+}
+
+// ClaimOutbox locks up to limit pending messages until now plus lease, so concurrent relays publish each
+// message once while its lock holds. A message whose lock expired, e.g. because its relay crashed, is claimed again.
+func (s *SQLStore) ClaimOutbox(now time.Time, lease time.Duration, limit int) ([]OutboxMessage, error) {
+	now = now.UTC()
+	query := `UPDATE outbox SET locked_until = $1, attempts = attempts + 1
+		WHERE sent_at IS NULL AND (locked_until IS NULL OR locked_until < $2) AND id IN (
+			SELECT id FROM outbox WHERE sent_at IS NULL AND (locked_until IS NULL OR locked_until < $2) ORDER BY id LIMIT $3)
+		RETURNING id, COALESCE(video_id, 0), exchange, routing_key, queue_name, payload, COALESCE(headers, ''),
+			attempts, COALESCE(last_error, ''), created_at`
+	rows, err := s.db.Query(query, now.Add(lease), now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []OutboxMessage
+	for rows.Next() {
+		var message OutboxMessage
+		var payload, headers string
+		err := rows.Scan(&message.ID, &message.VideoID, &message.Exchange, &message.RoutingKey, &message.Queue, &payload, &headers,
+			&message.Attempts, &message.LastError, &message.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		message.Payload = []byte(payload)
+		if headers != "" {
+			json.Unmarshal([]byte(headers), &message.Headers)
+		}
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING não garante a ordem
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages, nil
+}
+
+// MarkOutboxSent registers that the message was confirmed by the broker
+func (s *SQLStore) MarkOutboxSent(id int64) error {
+	_, err := s.db.Exec(`UPDATE outbox SET sent_at = $1, locked_until = NULL, last_error = NULL WHERE id = $2`, s.now(), id)
+	return err
+}
+
+// RecordOutboxError stores why the message could not be published, keeping its lock until the next attempt
+func (s *SQLStore) RecordOutboxError(id int64, lastError string) error {
+	_, err := s.db.Exec(`UPDATE outbox SET last_error = $1 WHERE id = $2`, sql.NullString{String: lastError, Valid: lastError != ""}, id)
+	return err
+}
+
+// OutboxRelay publishes the messages queued in the outbox. A message is marked sent only after the broker
+// confirms it, so a crash in between publishes it again: consumers get every message at least once.
+type OutboxRelay struct {
+	store     OutboxStore
+	publisher rabbitmq.RabbitClientInterface
+	interval  time.Duration // Poll interval, also picks up the messages left by crashed workers
+	lease     time.Duration
+	batchSize int
+	wake      chan struct{}
+}
+
+// NewOutboxRelay creates a relay polling the outbox every interval, 5s when zero
+func NewOutboxRelay(store OutboxStore, publisher rabbitmq.RabbitClientInterface, interval time.Duration) *OutboxRelay {
+	if interval <= 0 {
+		interval = defaultOutboxInterval
+	}
+	return &OutboxRelay{
+		store:     store,
+		publisher: publisher,
+		interval:  interval,
+		lease:     outboxLease,
+		batchSize: outboxBatchSize,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Notify wakes the relay up to publish a message just queued, instead of waiting for the next poll
+func (r *OutboxRelay) Notify() {
+	if r == nil {
+		return
+	}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run relays the pending messages until ctx is done
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.RelayPending(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("Failed to relay outbox messages", slog.String("error", err.Error()))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// RelayPending publishes the pending messages, returning how many were sent. The messages that could not
+// be published are attempted again by a later call, once their lock expires.
+func (r *OutboxRelay) RelayPending(ctx context.Context) (int, error) {
+	sent := 0
+	for {
+		messages, err := r.store.ClaimOutbox(time.Now(), r.lease, r.batchSize)
+		if err != nil {
+			return sent, err
+		}
+		for _, message := range messages {
+			if ctx.Err() != nil {
+				return sent, ctx.Err()
+			}
+
+			// O span de publicação continua o trace da conversão
+			publishCtx := otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(message.Headers))
+			headers := amqp.Table{}
+			for key, value := range message.Headers {
+				headers[key] = value
+			}
+			err := r.publisher.PublishMessage(publishCtx, message.Exchange, message.RoutingKey, message.Queue, message.Payload, headers)
+			if err != nil {
+				outboxRelayed.WithLabelValues("failed").Inc()
+				slog.Warn("Failed to publish outbox message", slog.Int64("id", message.ID), slog.Int("video_id", message.VideoID),
+					slog.String("routing_key", message.RoutingKey), slog.Int("attempts", message.Attempts), slog.String("error", err.Error()))
+				if err := r.store.RecordOutboxError(message.ID, err.Error()); err != nil {
+					slog.Warn("Failed to record outbox error", slog.Int64("id", message.ID), slog.String("error", err.Error()))
+				}
+				continue
+			}
+
+			// Se a marcação falhar a mensagem é publicada de novo quando o lock expirar
+			if err := r.store.MarkOutboxSent(message.ID); err != nil {
+				return sent, err
+			}
+			outboxRelayed.WithLabelValues("sent").Inc()
+			slog.Info("Published outbox message", slog.Int64("id", message.ID), slog.Int("video_id", message.VideoID), slog.String("routing_key", message.RoutingKey))
+			sent++
+		}
+		if len(messages) < r.batchSize {
+			return sent, nil
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package converter_test
+
+import (
+	"context"
+	"errors"
+	"imersaofc/internal/converter"
+	"imersaofc/pkg/contracts"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// queueConfirmation marks the video as processed with its confirmation in the outbox
+func queueConfirmation(t *testing.T, store *converter.SQLStore, videoID int, headers map[string]string) {
+	_, claimed, err := store.ClaimJob(converter.VideoTask{VideoID: videoID}, "worker-1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	assert.NoError(t, store.MarkProcessed(videoID, "worker-1", converter.OutboxMessage{VideoID: videoID, Exchange: "conversion_exchange",
+		RoutingKey: "finish-conversion", Queue: "video_confirmation_queue", Payload: []byte("{}"), Headers: headers}))
+}
+
+func TestOutboxRelayPublishesPending(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	store := openSQLiteStore(t)
+	publisher := &fakePublisher{}
+	relay := converter.NewOutboxRelay(store, publisher, time.Minute)
+
+	queueConfirmation(t, store, 1, map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		contracts.TypeHeader: "conversion.completed", contracts.VersionHeader: "1"})
+	queueConfirmation(t, store, 2, nil)
+
+	sent, err := relay.RelayPending(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, sent)
+
+	// A publicação continua o trace de quem gravou a mensagem
+	messages := publisher.messages()
+	assert.Len(t, messages, 2)
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	assert.Equal(t, traceID, messages[0].TraceID)
+	assert.Equal(t, "finish-conversion", messages[1].RoutingKey)
+	assert.Equal(t, "conversion.completed", messages[0].Headers[contracts.TypeHeader])
+	assert.Equal(t, "1", messages[0].Headers[contracts.VersionHeader])
+
+	// As mensagens enviadas não são publicadas de novo
+	sent, err = relay.RelayPending(context.Background())
+	assert.NoError(t, err)
+	assert.Zero(t, sent)
+	assert.Len(t, publisher.messages(), 2)
+}
+
+func TestOutboxRelayKeepsFailedMessages(t *testing.T) {
+	store := openSQLiteStore(t)
+	publisher := &fakePublisher{publishErr: errors.New("timed out waiting for the publish confirmation")}
+	relay := converter.NewOutboxRelay(store, publisher, time.Minute)
+	queueConfirmation(t, store, 1, nil)
+
+	sent, err := relay.RelayPending(context.Background())
+	assert.NoError(t, err)
+	assert.Zero(t, sent)
+
+	// A mensagem segue pendente com o erro, para a próxima tentativa
+	var lastError string
+	var attempts int
+	err = store.DB().QueryRow("SELECT last_error, attempts FROM outbox WHERE sent_at IS NULL").Scan(&lastError, &attempts)
+	assert.NoError(t, err)
+	assert.Equal(t, "timed out waiting for the publish confirmation", lastError)
+	assert.Equal(t, 1, attempts)
+
+	pending, err := store.ClaimOutbox(time.Now().Add(time.Hour), time.Minute, 10)
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1)
+}
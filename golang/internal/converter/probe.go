@@ -0,0 +1,133 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MediaInfo holds the properties of the merged file read by the probe step
+type MediaInfo struct {
+	Duration      float64 `json:"duration"` // Seconds
+	Container     string  `json:"container"`
+	VideoCodec    string  `json:"video_codec"`
+	AudioCodec    string  `json:"audio_codec,omitempty"`
+	Width         int     `json:"width"`
+	Height        int     `json:"height"`
+	FrameRate     float64 `json:"frame_rate"`
+	AudioChannels int     `json:"audio_channels"`
+	Rotation      int     `json:"rotation"` // Degrees, clockwise
+	HasAudio      bool    `json:"has_audio"`
+}
+
+// InvalidMediaError reports an input that is not a playable video
+type InvalidMediaError struct {
+	Reason string
+}
+
+func (e *InvalidMediaError) Error() string {
+	return fmt.Sprintf("invalid media: %s", e.Reason)
+}
+
+// Validate rejects inputs that cannot be converted
+func (m *MediaInfo) Validate() error {
+	switch {
+	case m.VideoCodec == "":
+		return &InvalidMediaError{Reason: "no video stream found"}
+	case m.Width <= 0 || m.Height <= 0:
+		return &InvalidMediaError{Reason: fmt.Sprintf("invalid resolution %dx%d", m.Width, m.Height)}
+	case m.Duration <= 0:
+		return &InvalidMediaError{Reason: "unknown or zero duration"}
+	}
+	return nil
+}
+
+// DisplaySize returns the resolution of the video as shown to the viewer, taking the rotation into account
+func (m *MediaInfo) DisplaySize() (int, int) {
+	if m.Rotation%180 != 0 {
+		return m.Height, m.Width
+	}
+	return m.Width, m.Height
+}
+
+// parseProbeOutput extracts the media info from the JSON written by
+// ffprobe -show_format -show_streams -of json
+func parseProbeOutput(output []byte) (*MediaInfo, error) {
+	var probe struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType    string            `json:"codec_type"`
+			CodecName    string            `json:"codec_name"`
+			Width        int               `json:"width"`
+			Height       int               `json:"height"`
+			AvgFrameRate string            `json:"avg_frame_rate"`
+			RFrameRate   string            `json:"r_frame_rate"`
+			Channels     int               `json:"channels"`
+			Tags         map[string]string `json:"tags"`
+			SideDataList []struct {
+				Rotation *int `json:"rotation"`
+			} `json:"side_data_list"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %v", err)
+	}
+
+	info := &MediaInfo{Container: probe.Format.FormatName}
+	info.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "video":
+			if info.VideoCodec != "" {
+				continue
+			}
+			info.VideoCodec = stream.CodecName
+			info.Width, info.Height = stream.Width, stream.Height
+			info.FrameRate = parseFrameRate(stream.AvgFrameRate)
+			if info.FrameRate == 0 {
+				info.FrameRate = parseFrameRate(stream.RFrameRate)
+			}
+
+			// Older files carry the rotation as a tag, newer ones as display matrix side data
+			if rotate, ok := stream.Tags["rotate"]; ok {
+				info.Rotation, _ = strconv.Atoi(rotate)
+			}
+			for _, sideData := range stream.SideDataList {
+				if sideData.Rotation != nil {
+					info.Rotation = -*sideData.Rotation
+				}
+			}
+			info.Rotation = ((info.Rotation % 360) + 360) % 360
+		case "audio":
+			if info.HasAudio {
+				continue
+			}
+			info.HasAudio = true
+			info.AudioCodec = stream.CodecName
+			info.AudioChannels = stream.Channels
+		}
+	}
+	return info, nil
+}
+
+// parseFrameRate converts a rational such as "30000/1001" into frames per second
+func parseFrameRate(rate string) float64 {
+	num, den, found := strings.Cut(rate, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !found {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
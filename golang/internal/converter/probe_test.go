@@ -0,0 +1,52 @@
+package converter
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const iphoneProbeOutput = `{
+	"streams": [
+		{"codec_type": "video", "codec_name": "hevc", "width": 1920, "height": 1080, "avg_frame_rate": "30000/1001", "r_frame_rate": "30/1",
+		 "side_data_list": [{"side_data_type": "Display Matrix", "rotation": -90}]},
+		{"codec_type": "audio", "codec_name": "aac", "channels": 2}
+	],
+	"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "12.345000"}
+}`
+
+func TestParseProbeOutput(t *testing.T) {
+	info, err := parseProbeOutput([]byte(iphoneProbeOutput))
+	assert.NoError(t, err)
+	assert.Equal(t, "mov,mp4,m4a,3gp,3g2,mj2", info.Container)
+	assert.Equal(t, "hevc", info.VideoCodec)
+	assert.Equal(t, "aac", info.AudioCodec)
+	assert.Equal(t, 12.345, info.Duration)
+	assert.InDelta(t, 29.97, info.FrameRate, 0.01)
+	assert.Equal(t, 2, info.AudioChannels)
+	assert.Equal(t, 90, info.Rotation)
+	assert.True(t, info.HasAudio)
+	assert.NoError(t, info.Validate())
+
+	// Vídeos em retrato usam a resolução rotacionada
+	width, height := info.DisplaySize()
+	assert.Equal(t, 1080, width)
+	assert.Equal(t, 1920, height)
+
+	_, err = parseProbeOutput([]byte("not json"))
+	assert.Error(t, err)
+}
+
+func TestMediaInfoValidate(t *testing.T) {
+	cases := map[string]MediaInfo{
+		"audio only":    {Container: "mp3", AudioCodec: "mp3", Duration: 10, HasAudio: true},
+		"no resolution": {Container: "mp4", VideoCodec: "h264", Duration: 10},
+		"no duration":   {Container: "mp4", VideoCodec: "h264", Width: 640, Height: 360},
+	}
+	for name, info := range cases {
+		err := info.Validate()
+		var invalid *InvalidMediaError
+		assert.True(t, errors.As(err, &invalid), name)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package converter
+
+import "os/exec"
+
+// setProcessGroup is a no-op where process groups are not available, the
+// context cancellation kills the process itself
+func setProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,133 @@
+package converter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"imersaofc/pkg/rabbitmq"
+)
+
+// Phase identifies the step of the conversion a progress event refers to
+type Phase string
+
+const (
+	PhaseMerging   Phase = "merging"
+	PhaseProbing   Phase = "probing"
+	PhaseEncoding  Phase = "encoding"
+	PhasePackaging Phase = "packaging"
+)
+
+// defaultProgressInterval is the minimum delay between two progress events of the same phase
+const defaultProgressInterval = 2 * time.Second
+
+// ProgressEvent is published periodically while a video is converted
+type ProgressEvent struct {
+	VideoID    int       `json:"video_id"`
+	Phase      Phase     `json:"phase"`
+	Percent    float64   `json:"percent"`
+	ETASeconds *float64  `json:"eta_seconds,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// TranscodeProgress is the position reported by the transcoder while encoding
+type TranscodeProgress struct {
+	OutTime time.Duration // Media time already encoded
+	Speed   float64       // Encoding speed as a multiple of realtime, zero when unknown
+	Done    bool
+}
+
+// progressReporter publishes the progress of a single video, dropping events sent
+// less than interval apart unless the phase changed or the phase finished.
+// A nil reporter discards every event.
+type progressReporter struct {
+	ctx        context.Context
+	publisher  rabbitmq.RabbitClientInterface
+	exchange   string
+	routingKey string
+	queueName  string
+	videoID    int
+	interval   time.Duration
+	now        func() time.Time
+
+	mu        sync.Mutex
+	lastPhase Phase
+	lastSent  time.Time
+}
+
+// Report publishes the progress of the phase, eta is ignored when negative
+func (r *progressReporter) Report(phase Phase, percent float64, eta time.Duration) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	now := r.now()
+	if phase == r.lastPhase && percent < 100 && now.Sub(r.lastSent) < r.interval {
+		r.mu.Unlock()
+		return
+	}
+	r.lastPhase, r.lastSent = phase, now
+	r.mu.Unlock()
+
+	event := ProgressEvent{
+		VideoID:   r.videoID,
+		Phase:     phase,
+		Percent:   min(max(percent, 0), 100),
+		Timestamp: now,
+	}
+	if eta >= 0 {
+		seconds := eta.Seconds()
+		event.ETASeconds = &seconds
+	}
+
+	message, _ := json.Marshal(event)
+	if err := r.publisher.PublishMessage(r.ctx, r.exchange, r.routingKey, r.queueName, message, nil); err != nil {
+		slog.Warn("Failed to publish progress event", slog.Int("video_id", r.videoID), slog.String("error", err.Error()))
+	}
+}
+
+// ReportEncoding converts the transcoder position into a percentage and an ETA of the encoding phase
+func (r *progressReporter) ReportEncoding(p TranscodeProgress, duration float64) {
+	if p.Done {
+		r.Report(PhaseEncoding, 100, 0)
+		return
+	}
+
+	encoded := p.OutTime.Seconds()
+	eta := time.Duration(-1)
+	if p.Speed > 0 {
+		eta = max(time.Duration((duration-encoded)/p.Speed*float64(time.Second)), 0)
+	}
+	r.Report(PhaseEncoding, encoded/duration*100, eta)
+}
+
+// parseProgress reads the key=value blocks written by ffmpeg -progress and calls fn at the end of each block
+func parseProgress(r io.Reader, fn func(TranscodeProgress)) {
+	var current TranscodeProgress
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !found {
+			continue
+		}
+
+		switch key {
+		case "out_time_us", "out_time_ms": // out_time_ms is also expressed in microseconds
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+				current.OutTime = time.Duration(us) * time.Microsecond
+			}
+		case "speed":
+			current.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		case "progress":
+			current.Done = value == "end"
+			fn(current)
+		}
+	}
+}
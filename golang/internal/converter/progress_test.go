@@ -0,0 +1,106 @@
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"imersaofc/pkg/rabbitmq"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+const ffmpegProgressOutput = `frame=120
+fps=60.00
+out_time_us=4000000
+out_time=00:00:04.000000
+speed=2.00x
+progress=continue
+frame=300
+out_time_us=10000000
+speed=N/A
+progress=end
+`
+
+func TestParseProgress(t *testing.T) {
+	var updates []TranscodeProgress
+	parseProgress(strings.NewReader(ffmpegProgressOutput), func(p TranscodeProgress) {
+		updates = append(updates, p)
+	})
+
+	assert.Equal(t, []TranscodeProgress{
+		{OutTime: 4 * time.Second, Speed: 2},
+		{OutTime: 10 * time.Second, Speed: 0, Done: true},
+	}, updates)
+}
+
+// recordingPublisher keeps the published bodies, for the reporter tests
+type recordingPublisher struct {
+	bodies [][]byte
+}
+
+func (p *recordingPublisher) ConsumeMessages(exchange, routingKey, queueName string) (<-chan amqp.Delivery, error) {
+	return nil, nil
+}
+
+func (p *recordingPublisher) PublishMessage(ctx context.Context, exchange, routingKey, queueName string, message []byte, headers amqp.Table) error {
+	p.bodies = append(p.bodies, message)
+	return nil
+}
+
+func (p *recordingPublisher) Retry(d amqp.Delivery, queueName string, policy rabbitmq.RetryPolicy, retryable bool, reason string) (bool, error) {
+	return false, nil
+}
+
+func (p *recordingPublisher) Park(d amqp.Delivery, queueName string, reason string) error {
+	return nil
+}
+
+func (p *recordingPublisher) Close() error   { return nil }
+func (p *recordingPublisher) IsClosed() bool { return false }
+
+func TestProgressReporterRateLimit(t *testing.T) {
+	publisher := &recordingPublisher{}
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	reporter := &progressReporter{
+		publisher: publisher,
+		videoID:   7,
+		interval:  2 * time.Second,
+		now:       func() time.Time { return clock },
+	}
+
+	reporter.Report(PhaseMerging, 0, -1)
+	reporter.Report(PhaseMerging, 50, -1) // Descartado: mesmo intervalo
+	reporter.Report(PhaseEncoding, 0, -1) // Mudança de fase
+	clock = clock.Add(time.Second)
+	reporter.ReportEncoding(TranscodeProgress{OutTime: 5 * time.Second, Speed: 2}, 20) // Descartado
+	clock = clock.Add(time.Second)
+	reporter.ReportEncoding(TranscodeProgress{OutTime: 10 * time.Second, Speed: 2}, 20)
+	reporter.ReportEncoding(TranscodeProgress{OutTime: 20 * time.Second, Done: true}, 20) // Fim da fase
+
+	var events []ProgressEvent
+	for _, body := range publisher.bodies {
+		var event ProgressEvent
+		assert.NoError(t, json.Unmarshal(body, &event))
+		events = append(events, event)
+	}
+
+	assert.Len(t, events, 4)
+	assert.Equal(t, PhaseMerging, events[0].Phase)
+	assert.Nil(t, events[0].ETASeconds)
+	assert.Equal(t, PhaseEncoding, events[2].Phase)
+	assert.Equal(t, 50.0, events[2].Percent)
+	assert.Equal(t, 5.0, *events[2].ETASeconds)
+	assert.Equal(t, 100.0, events[3].Percent)
+}
+
+func TestNilProgressReporter(t *testing.T) {
+	var reporter *progressReporter
+	assert.NotPanics(t, func() {
+		reporter.Report(PhaseMerging, 0, -1)
+		reporter.ReportEncoding(TranscodeProgress{Done: true}, 10)
+	})
+}
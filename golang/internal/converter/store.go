@@ -0,0 +1,117 @@
+package converter
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// JobStore persists the conversion jobs, the probed metadata and the error log of the videos
+type JobStore interface {
+	// MarkProcessed registers that the job of the video running on workerID has succeeded, queuing the events in the outbox
+	MarkProcessed(videoID int, workerID string, events ...OutboxMessage) error
+	// RegisterError stores a failed attempt in the error log
+	RegisterError(record ErrorRecord) error
+	// ErrorsByVideo lists the errors of the video, oldest first
+	ErrorsByVideo(videoID int) ([]ErrorRecord, error)
+	// ErrorsByCode lists the latest errors with the code since the given time, at most limit
+	ErrorsByCode(code ErrorCode, since time.Time, limit int) ([]ErrorRecord, error)
+	// CountErrorsByCode counts the errors of each code since the given time
+	CountErrorsByCode(since time.Time) (map[ErrorCode]int, error)
+	// SaveMetadata stores the probed media info of the video, replacing any previous probe
+	SaveMetadata(videoID int, info *MediaInfo) error
+
+	// GetJob loads the conversion job of the video, ErrJobNotFound when there is none
+	GetJob(videoID int) (*Job, error)
+	// ClaimJob moves the job of the video to running on behalf of workerID, see SQLStore.ClaimJob
+	ClaimJob(task VideoTask, workerID string, lease time.Duration) (job *Job, claimed bool, err error)
+	// FindJobs lists the jobs matching the filter, ordered by video ID
+	FindJobs(filter JobFilter) ([]Job, error)
+	// StartPhase records the phase the running job of the video has entered
+	StartPhase(videoID int, phase Phase) error
+	// Transition moves the job of the video to the given state, ErrInvalidTransition when not allowed
+	Transition(videoID int, to JobState, lastError string) error
+	// ReleaseJob moves the job of the video running on workerID to queued or failed, ErrLeaseLost when it no longer runs there
+	ReleaseJob(videoID int, workerID string, to JobState, lastError string) error
+	// RenewLease extends the lease of the job running on workerID, ErrLeaseLost when it was taken over
+	RenewLease(videoID int, workerID string, lease time.Duration) error
+	// RequeueExpiredJobs queues again the running jobs whose lease expired before now, with their conversion message in the outbox
+	RequeueExpiredJobs(now time.Time, exchange, routingKey, queue string) (int, error)
+
+	Close() error
+}
+
+// Supported store drivers
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// SQLStore implements JobStore on PostgreSQL or SQLite. The queries are written in the SQL both understand
+// (numbered placeholders, ON CONFLICT and RETURNING) and every timestamp is stored in UTC, so SQLite can
+// compare them as text.
+type SQLStore struct {
+	db     *sql.DB
+	driver string
+}
+
+// NewPostgresStore creates a store on a PostgreSQL database, its schema is created by MigrateUp
+func NewPostgresStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db, driver: DriverPostgres}
+}
+
+// OpenSQLiteStore opens the SQLite database at path, creating it when needed, its schema is created by MigrateUp.
+// It is meant for single-node deployments and tests, ":memory:" gives a private in-memory database.
+func OpenSQLiteStore(path string) (*SQLStore, error) {
+	db, err := sql.Open(DriverSQLite, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %v", err)
+	}
+	// SQLite só aceita uma escrita por vez, uma conexão evita "database is locked"
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec("PRAGMA busy_timeout = 5000; PRAGMA journal_mode = WAL;"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to configure SQLite database: %v", err)
+	}
+
+	return &SQLStore{db: db, driver: DriverSQLite}, nil
+}
+
+// OpenStore opens the store of the given driver, dsn being a PostgreSQL connection string or a SQLite file path.
+// The schema is left as is for both drivers, the caller applies the migrations with MigrateUp.
+func OpenStore(driver, dsn string) (*SQLStore, error) {
+	switch driver {
+	case DriverPostgres:
+		db, err := sql.Open(DriverPostgres, dsn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open PostgreSQL database: %v", err)
+		}
+		if err := db.Ping(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to ping PostgreSQL database: %v", err)
+		}
+		return NewPostgresStore(db), nil
+	case DriverSQLite:
+		return OpenSQLiteStore(dsn)
+	}
+	return nil, fmt.Errorf("unknown store driver %q", driver)
+}
+
+// DB returns the underlying database
+func (s *SQLStore) DB() *sql.DB {
+	return s.db
+}
+
+// Close closes the underlying database
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// now is the time stored in the timestamp columns
+func (s *SQLStore) now() time.Time {
+	return time.Now().UTC()
+}
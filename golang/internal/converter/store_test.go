@@ -0,0 +1,385 @@
+package converter_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"imersaofc/internal/converter"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// openSQLiteStore creates an empty in-memory store with every migration applied, closed at the end of the test
+func openSQLiteStore(t *testing.T) *converter.SQLStore {
+	store, err := converter.OpenSQLiteStore(":memory:")
+	assert.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	_, err = store.MigrateUp(context.Background())
+	assert.NoError(t, err)
+	return store
+}
+
+// testMarkProcessed checks that only a job running on the worker can be marked as processed
+func testMarkProcessed(t *testing.T, store converter.JobStore) {
+	assert.ErrorIs(t, store.MarkProcessed(2, "worker-1"), converter.ErrLeaseLost)
+	_, err := store.GetJob(2)
+	assert.ErrorIs(t, err, converter.ErrJobNotFound)
+
+	_, claimed, err := store.ClaimJob(converter.VideoTask{VideoID: 2, Path: "/media/uploads/2"}, "worker-1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	assert.NoError(t, store.MarkProcessed(2, "worker-1"))
+
+	job, err := store.GetJob(2)
+	assert.NoError(t, err)
+	assert.Equal(t, converter.JobSucceeded, job.State)
+	assert.NotNil(t, job.FinishedAt)
+	assert.Nil(t, job.LeaseExpiry)
+}
+
+// testReleaseJob checks that a worker only releases the job running on it, never one that succeeded or was cancelled
+func testReleaseJob(t *testing.T, store converter.JobStore) {
+	task := converter.VideoTask{VideoID: 6, Path: "/media/uploads/6"}
+	_, claimed, err := store.ClaimJob(task, "worker-1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	assert.NoError(t, store.ReleaseJob(6, "worker-1", converter.JobQueued, "encoder crashed"))
+	assert.ErrorIs(t, store.ReleaseJob(6, "worker-1", converter.JobFailed, "encoder crashed"), converter.ErrLeaseLost)
+
+	// Um worker atrasado não devolve para a fila um job que já terminou
+	_, claimed, err = store.ClaimJob(task, "worker-1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	assert.NoError(t, store.MarkProcessed(6, "worker-1"))
+	assert.ErrorIs(t, store.ReleaseJob(6, "worker-1", converter.JobQueued, "shutting down"), converter.ErrLeaseLost)
+	job, err := store.GetJob(6)
+	assert.NoError(t, err)
+	assert.Equal(t, converter.JobSucceeded, job.State)
+
+	// Nem desfaz o cancelamento feito por um operador
+	_, claimed, err = store.ClaimJob(converter.VideoTask{VideoID: 6, Path: "/media/uploads/6", Force: true}, "worker-1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	assert.NoError(t, store.Transition(6, converter.JobCancelled, "cancelled by operator"))
+	assert.ErrorIs(t, store.ReleaseJob(6, "worker-1", converter.JobFailed, "exit status 1"), converter.ErrLeaseLost)
+	job, err = store.GetJob(6)
+	assert.NoError(t, err)
+	assert.Equal(t, converter.JobCancelled, job.State)
+
+	assert.ErrorIs(t, store.ReleaseJob(6, "worker-1", converter.JobSucceeded, ""), converter.ErrInvalidTransition)
+}
+
+// testClaimJob checks that a job runs on a single worker at a time
+func testClaimJob(t *testing.T, store converter.JobStore) {
+	task := converter.VideoTask{VideoID: 3, Path: "/media/uploads/3"}
+
+	// O primeiro worker cria o job e o assume
+	job, claimed, err := store.ClaimJob(task, "worker-1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	assert.Equal(t, converter.JobRunning, job.State)
+	assert.Equal(t, 1, job.Attempts)
+	assert.Equal(t, "worker-1", job.WorkerID)
+	assert.Equal(t, "/media/uploads/3", job.Path)
+
+	// Uma entrega duplicada não pode iniciar outra conversão
+	job, claimed, err = store.ClaimJob(task, "worker-2", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+	assert.Equal(t, "worker-1", job.WorkerID)
+
+	assert.NoError(t, store.StartPhase(3, converter.PhaseEncoding))
+	job, err = store.GetJob(3)
+	assert.NoError(t, err)
+	assert.Equal(t, converter.PhaseEncoding, job.Phase)
+	assert.NotNil(t, job.EncodingAt)
+
+	// Depois de voltar para a fila, o retry é assumido por outro worker
+	assert.NoError(t, store.Transition(3, converter.JobQueued, "encoder crashed"))
+	job, claimed, err = store.ClaimJob(task, "worker-2", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	assert.Equal(t, 2, job.Attempts)
+	assert.Equal(t, "worker-2", job.WorkerID)
+	assert.Nil(t, job.EncodingAt)
+
+	// Jobs cancelados não são assumidos
+	assert.NoError(t, store.Transition(3, converter.JobCancelled, "cancelled by operator"))
+	_, claimed, err = store.ClaimJob(task, "worker-1", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+	assert.ErrorIs(t, store.Transition(3, converter.JobSucceeded, ""), converter.ErrInvalidTransition)
+
+	_, err = store.GetJob(999)
+	assert.ErrorIs(t, err, converter.ErrJobNotFound)
+}
+
+// testForceClaimJob checks that a forced task claims a job that already finished, but never a running one
+func testForceClaimJob(t *testing.T, store converter.JobStore) {
+	task := converter.VideoTask{VideoID: 5, Path: "/media/uploads/5"}
+	_, claimed, err := store.ClaimJob(task, "worker-1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	// Um job em execução não é assumido nem com force
+	task.Force = true
+	_, claimed, err = store.ClaimJob(task, "worker-2", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+
+	assert.NoError(t, store.MarkProcessed(5, "worker-1"))
+	task.Force = false
+	_, claimed, err = store.ClaimJob(task, "worker-2", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+
+	// O reprocessamento converte de novo um vídeo já processado
+	task.Force = true
+	job, claimed, err := store.ClaimJob(task, "worker-2", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	assert.Equal(t, converter.JobRunning, job.State)
+	assert.Equal(t, 2, job.Attempts)
+	assert.Nil(t, job.FinishedAt)
+
+	assert.NoError(t, store.Transition(5, converter.JobCancelled, "cancelled by operator"))
+	_, claimed, err = store.ClaimJob(task, "worker-1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+}
+
+// testFindJobs checks the filters of FindJobs
+func testFindJobs(t *testing.T, store converter.JobStore) {
+	for id := 10; id <= 14; id++ {
+		_, _, err := store.ClaimJob(converter.VideoTask{VideoID: id, Path: fmt.Sprintf("/media/uploads/%d", id)}, "worker-1", time.Minute)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, store.Transition(11, converter.JobFailed, "exit status 1"))
+	assert.NoError(t, store.Transition(13, converter.JobFailed, "exit status 1"))
+	assert.NoError(t, store.MarkProcessed(12, "worker-1"))
+
+	ids := func(jobs []converter.Job) []int {
+		var ids []int
+		for _, job := range jobs {
+			ids = append(ids, job.VideoID)
+		}
+		return ids
+	}
+
+	jobs, err := store.FindJobs(converter.JobFilter{FromID: 10, ToID: 14})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{10, 11, 12, 13, 14}, ids(jobs))
+
+	jobs, err = store.FindJobs(converter.JobFilter{FromID: 10, ToID: 14, States: []converter.JobState{converter.JobFailed}})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{11, 13}, ids(jobs))
+	assert.Equal(t, "exit status 1", jobs[0].LastError)
+
+	jobs, err = store.FindJobs(converter.JobFilter{FromID: 11, ToID: 13, States: []converter.JobState{converter.JobFailed, converter.JobSucceeded}, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{11, 12}, ids(jobs))
+
+	jobs, err = store.FindJobs(converter.JobFilter{FromID: 100})
+	assert.NoError(t, err)
+	assert.Empty(t, jobs)
+}
+
+// testJobLease checks that expired leases are requeued and can be claimed again
+func testJobLease(t *testing.T, store *converter.SQLStore) {
+	task := converter.VideoTask{VideoID: 4, Path: "/media/uploads/4"}
+	_, claimed, err := store.ClaimJob(task, "worker-1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	assert.NoError(t, store.RenewLease(4, "worker-1", -time.Second))
+
+	// Com o lease expirado o reaper devolve o job para a fila e deixa a nova mensagem no outbox
+	count, err := store.RequeueExpiredJobs(time.Now(), "conversion_exchange", "conversion", "video_conversion_queue")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	pending, err := store.ClaimOutbox(time.Now(), time.Minute, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, 4, pending[0].VideoID)
+		assert.Equal(t, "video_conversion_queue", pending[0].Queue)
+		assert.JSONEq(t, `{"video_id": 4, "path": "/media/uploads/4"}`, string(pending[0].Payload))
+		assert.Equal(t, "conversion.requested", pending[0].Headers["x-message-type"])
+	}
+
+	job, err := store.GetJob(4)
+	assert.NoError(t, err)
+	assert.Equal(t, converter.JobQueued, job.State)
+
+	// O worker antigo perdeu o job
+	assert.ErrorIs(t, store.RenewLease(4, "worker-1", time.Minute), converter.ErrLeaseLost)
+
+	// Uma entrega duplicada assume um job cujo lease expirou
+	_, claimed, err = store.ClaimJob(task, "worker-2", -time.Second)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	_, claimed, err = store.ClaimJob(task, "worker-3", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	// Os workers antigos não terminam nem devolvem o job que agora roda em outro worker
+	assert.ErrorIs(t, store.MarkProcessed(4, "worker-1"), converter.ErrLeaseLost)
+	assert.ErrorIs(t, store.ReleaseJob(4, "worker-2", converter.JobQueued, "lease expired"), converter.ErrLeaseLost)
+	assert.ErrorIs(t, store.ReleaseJob(4, "worker-1", converter.JobFailed, "exit status 1"), converter.ErrLeaseLost)
+	job, err = store.GetJob(4)
+	assert.NoError(t, err)
+	assert.Equal(t, converter.JobRunning, job.State)
+	assert.Equal(t, "worker-3", job.WorkerID)
+	assert.NoError(t, store.MarkProcessed(4, "worker-3"))
+}
+
+// testRegisterError checks that the errors are stored in typed columns and can be queried per video and per code
+func testRegisterError(t *testing.T, store *converter.SQLStore) {
+	since := time.Now().Add(-time.Minute)
+	records := []converter.ErrorRecord{
+		{VideoID: 1, Phase: converter.PhaseMerging, Code: converter.CodeMergeFailed, Attempt: 1, Retryable: true, Message: "Error during video conversion", Detail: "failed to merge chunks"},
+		{VideoID: 1, Phase: converter.PhaseEncoding, Code: converter.CodeEncodeFailed, Attempt: 2, Retryable: true, Message: "Error during video conversion", Detail: "exit status 1: Conversion failed!", Stderr: "Invalid data found\nConversion failed!"},
+		{VideoID: 2, Phase: converter.PhaseEncoding, Code: converter.CodeEncodeFailed, Attempt: 1, Retryable: true, Message: "Error during video conversion", Detail: "exit status 1"},
+		{Code: converter.CodeInvalidMessage, Attempt: 1, Message: "Failed to deserialize message", Detail: "unexpected end of JSON input"},
+	}
+	for i, record := range records {
+		record.CreatedAt = time.Now().Add(time.Duration(i) * time.Millisecond)
+		assert.NoError(t, store.RegisterError(record))
+	}
+
+	// O histórico do vídeo mostra cada tentativa na ordem
+	history, err := store.ErrorsByVideo(1)
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, converter.PhaseMerging, history[0].Phase)
+	assert.Equal(t, 2, history[1].Attempt)
+	assert.Equal(t, converter.CodeEncodeFailed, history[1].Code)
+	assert.Equal(t, "Invalid data found\nConversion failed!", history[1].Stderr)
+	assert.True(t, history[1].Retryable)
+
+	encodeErrors, err := store.ErrorsByCode(converter.CodeEncodeFailed, since, 1)
+	assert.NoError(t, err)
+	assert.Len(t, encodeErrors, 1)
+	assert.Equal(t, 2, encodeErrors[0].VideoID)
+
+	counts, err := store.CountErrorsByCode(since)
+	assert.NoError(t, err)
+	assert.Equal(t, map[converter.ErrorCode]int{converter.CodeMergeFailed: 1, converter.CodeEncodeFailed: 2, converter.CodeInvalidMessage: 1}, counts)
+
+	// error_details keeps the JSON of the record
+	var errorDetails string
+	err = store.DB().QueryRow("SELECT error_details FROM process_errors_log WHERE id = 1").Scan(&errorDetails)
+	assert.NoError(t, err)
+	var loggedError map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(errorDetails), &loggedError))
+	assert.Equal(t, float64(1), loggedError["video_id"])
+	assert.Equal(t, "merge_failed", loggedError["code"])
+}
+
+// testOutbox checks that the outbox messages are queued with the job state and claimed by one relay at a time
+func testOutbox(t *testing.T, store *converter.SQLStore) {
+	message := converter.OutboxMessage{VideoID: 3, Exchange: "conversion_exchange", RoutingKey: "finish-conversion",
+		Queue: "video_confirmation_queue", Payload: []byte(`{"video_id":3}`), Headers: map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}
+
+	// Sem a mudança de estado a mensagem também não é gravada
+	assert.ErrorIs(t, store.MarkProcessed(3, "worker-1", message), converter.ErrLeaseLost)
+	now := time.Now()
+	pending, err := store.ClaimOutbox(now, time.Minute, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+
+	_, claimed, err := store.ClaimJob(converter.VideoTask{VideoID: 3, Path: "/media/uploads/3"}, "worker-1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	assert.NoError(t, store.MarkProcessed(3, "worker-1", message))
+
+	pending, err = store.ClaimOutbox(now, time.Minute, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, message.Payload, pending[0].Payload)
+		assert.Equal(t, message.Headers, pending[0].Headers)
+		assert.Equal(t, "finish-conversion", pending[0].RoutingKey)
+		assert.Equal(t, 1, pending[0].Attempts)
+	}
+
+	// Enquanto o lock vale nenhum outro relay pega a mensagem
+	locked, err := store.ClaimOutbox(now, time.Minute, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, locked)
+
+	// Depois de uma falha ela volta quando o lock expira
+	assert.NoError(t, store.RecordOutboxError(pending[0].ID, "message nacked by the broker"))
+	retried, err := store.ClaimOutbox(now.Add(2*time.Minute), time.Minute, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, retried, 1) {
+		assert.Equal(t, 2, retried[0].Attempts)
+		assert.Equal(t, "message nacked by the broker", retried[0].LastError)
+	}
+
+	assert.NoError(t, store.MarkOutboxSent(pending[0].ID))
+	sent, err := store.ClaimOutbox(now.Add(time.Hour), time.Minute, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, sent)
+}
+
+func TestSQLiteStoreMarkProcessed(t *testing.T) {
+	testMarkProcessed(t, openSQLiteStore(t))
+}
+
+func TestSQLiteStoreReleaseJob(t *testing.T) {
+	testReleaseJob(t, openSQLiteStore(t))
+}
+
+func TestSQLiteStoreClaimJob(t *testing.T) {
+	testClaimJob(t, openSQLiteStore(t))
+}
+
+func TestSQLiteStoreForceClaimJob(t *testing.T) {
+	testForceClaimJob(t, openSQLiteStore(t))
+}
+
+func TestSQLiteStoreFindJobs(t *testing.T) {
+	testFindJobs(t, openSQLiteStore(t))
+}
+
+func TestSQLiteStoreJobLease(t *testing.T) {
+	testJobLease(t, openSQLiteStore(t))
+}
+
+func TestSQLiteStoreOutbox(t *testing.T) {
+	testOutbox(t, openSQLiteStore(t))
+}
+
+func TestSQLiteStoreRegisterError(t *testing.T) {
+	testRegisterError(t, openSQLiteStore(t))
+}
+
+func TestSQLiteStoreSaveMetadata(t *testing.T) {
+	store := openSQLiteStore(t)
+	info := converter.NewFakeTranscoder().Info
+
+	// Um novo probe substitui o anterior
+	assert.NoError(t, store.SaveMetadata(1, &info))
+	info.Duration = 90
+	assert.NoError(t, store.SaveMetadata(1, &info))
+
+	var duration float64
+	assert.NoError(t, store.DB().QueryRow("SELECT duration_seconds FROM video_metadata WHERE video_id = $1", 1).Scan(&duration))
+	assert.Equal(t, float64(90), duration)
+}
+
+func TestOpenStore(t *testing.T) {
+	store, err := converter.OpenStore(converter.DriverSQLite, ":memory:")
+	assert.NoError(t, err)
+	// As migrations ficam para o chamador, como no PostgreSQL
+	status, err := store.MigrationStatus(context.Background())
+	assert.NoError(t, err)
+	for _, migration := range status {
+		assert.Nil(t, migration.AppliedAt, migration.Name)
+	}
+	assert.NoError(t, store.Close())
+
+	_, err = converter.OpenStore("mysql", "")
+	assert.Error(t, err)
+}
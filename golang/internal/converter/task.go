@@ -2,115 +2,541 @@ package converter
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"time"
+
+	"imersaofc/pkg/contracts"
 	"imersaofc/pkg/rabbitmq"
+
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // VideoConverter handles video conversion tasks
 type VideoConverter struct {
-	rabbitClient *rabbitmq.RabbitClient
-	db           *sql.DB
+	rabbitClient rabbitmq.RabbitClientInterface
+	store        JobStore
+	transcoder   Transcoder
 	rootPath     string
+	ladder       Ladder
+	workerID     string
+
+	leaseDuration time.Duration
+
+	progressKey      string
+	progressQueue    string
+	progressInterval time.Duration
+
+	timeoutBase   time.Duration
+	timeoutFactor float64
+	timeoutMax    time.Duration
+
+	queueName   string
+	retryPolicy rabbitmq.RetryPolicy
+
+	failureKey   string
+	failureQueue string
+
+	relay *OutboxRelay
+}
+
+// Config holds the settings of a VideoConverter
+type Config struct {
+	RootPath string // Directory containing one folder of chunks per video
+	Ladder   Ladder // Renditions encoded for each video, DefaultLadder when empty
+	WorkerID string // Identifies this worker in the claimed conversion jobs
+
+	LeaseDuration time.Duration // Lifetime of the claim on a job, renewed by a heartbeat, 60s when zero
+
+	ProgressKey      string        // Routing key of the progress events, disabled when empty
+	ProgressQueue    string        // Queue bound to ProgressKey
+	ProgressInterval time.Duration // Minimum delay between two progress events, 2s when zero
+
+	TimeoutBase   time.Duration // Fixed part of the per-job timeout, 10m when zero
+	TimeoutFactor float64       // Seconds added to the timeout per second of input, 5 when zero
+	TimeoutMax    time.Duration // Upper bound of the per-job timeout, 6h when zero
+
+	QueueName   string               // Queue the conversion messages come from, retries are disabled when empty
+	RetryPolicy rabbitmq.RetryPolicy // Attempts and backoff of failed conversions, rabbitmq.DefaultRetryPolicy when zero
+
+	FailureKey   string // Routing key of the conversion-failed events, disabled when empty
+	FailureQueue string // Queue bound to FailureKey
+
+	Relay *OutboxRelay // Notified when a confirmation is queued in the outbox, else it is published on the next poll
 }
 
 // VideoTask represents a video conversion task
 type VideoTask struct {
 	VideoID int    `json:"video_id"`
 	Path    string `json:"path"`
+	Force   bool   `json:"force,omitempty"` // Convert again a video already processed, archiving its output
+}
+
+// ConversionResult is the confirmation published once a video has been converted
+type ConversionResult struct {
+	VideoID      int           `json:"video_id"`
+	Path         string        `json:"path"`
+	DashManifest string        `json:"dash_manifest"`
+	HLSManifest  string        `json:"hls_manifest"`
+	Metadata     *MediaInfo    `json:"metadata"`
+	Thumbnails   *ThumbnailSet `json:"thumbnails,omitempty"`
+
+	archiveDir string // Previous output archived by a forced conversion
+}
+
+// ConversionFailure is published each time a conversion attempt fails
+type ConversionFailure struct {
+	VideoID   int       `json:"video_id"`
+	Phase     Phase     `json:"phase"`
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	Detail    string    `json:"detail"`
+	Attempt   int       `json:"attempt"`
+	Retryable bool      `json:"retryable"`
+	WillRetry bool      `json:"will_retry"` // False once the job is dead-lettered, the video will not be converted
+	Timestamp time.Time `json:"timestamp"`
 }
 
-// NewVideoConverter creates a new instance of VideoConverter
-func NewVideoConverter(rabbitClient *rabbitmq.RabbitClient, db *sql.DB, rootPath string) *VideoConverter {
+// NewVideoConverter creates a new instance of VideoConverter.
+// A nil transcoder defaults to the ffmpeg implementation.
+func NewVideoConverter(rabbitClient rabbitmq.RabbitClientInterface, store JobStore, transcoder Transcoder, cfg Config) *VideoConverter {
+	if transcoder == nil {
+		transcoder = NewFFmpegTranscoder()
+	}
+
+	ladder := cfg.Ladder
+	if len(ladder) == 0 {
+		ladder = DefaultLadder()
+	}
+
+	progressInterval := cfg.ProgressInterval
+	if progressInterval <= 0 {
+		progressInterval = defaultProgressInterval
+	}
+
+	timeoutBase := cfg.TimeoutBase
+	if timeoutBase <= 0 {
+		timeoutBase = defaultTimeoutBase
+	}
+	timeoutFactor := cfg.TimeoutFactor
+	if timeoutFactor <= 0 {
+		timeoutFactor = defaultTimeoutFactor
+	}
+	timeoutMax := cfg.TimeoutMax
+	if timeoutMax <= 0 {
+		timeoutMax = defaultTimeoutMax
+	}
+
+	leaseDuration := cfg.LeaseDuration
+	if leaseDuration <= 0 {
+		leaseDuration = defaultLeaseDuration
+	}
+
+	retryPolicy := cfg.RetryPolicy
+	if retryPolicy.MaxAttempts <= 0 {
+		retryPolicy = rabbitmq.DefaultRetryPolicy()
+	}
+
 	return &VideoConverter{
-		rabbitClient: rabbitClient,
-		db:           db,
-		rootPath:     rootPath,
+		rabbitClient:     rabbitClient,
+		store:            store,
+		transcoder:       transcoder,
+		rootPath:         cfg.RootPath,
+		ladder:           ladder,
+		workerID:         cfg.WorkerID,
+		leaseDuration:    leaseDuration,
+		progressKey:      cfg.ProgressKey,
+		progressQueue:    cfg.ProgressQueue,
+		progressInterval: progressInterval,
+		timeoutBase:      timeoutBase,
+		timeoutFactor:    timeoutFactor,
+		timeoutMax:       timeoutMax,
+		queueName:        cfg.QueueName,
+		retryPolicy:      retryPolicy,
+		failureKey:       cfg.FailureKey,
+		failureQueue:     cfg.FailureQueue,
+		relay:            cfg.Relay,
 	}
 }
 
-// HandleMessage processes a video conversion message
+// HandleMessage processes a video conversion message, a conversion.requested v1 message. Messages of another
+// type or version are moved to the parking queue, messages not matching the schema are dead-lettered.
+// The conversion job of the video is claimed first, so a video is only converted by one worker at a time,
+// and its lease is renewed while the conversion runs.
+// When ctx is canceled the running conversion is stopped and the message is requeued for another worker.
+// Failed conversions are retried with backoff according to the retry policy, then dead-lettered.
+// The conversion result is queued in the outbox in the same transaction that marks the job as succeeded,
+// and published by the OutboxRelay.
 func (vc *VideoConverter) HandleMessage(ctx context.Context, d amqp.Delivery, conversionExch, confirmationKey, confirmationQueue string) {
 	var task VideoTask
+	attempt := rabbitmq.RetryCount(d) + 1
+	messagesReceived.Inc()
+
+	if ctx.Err() != nil {
+		d.Nack(false, true)
+		return
+	}
+
+	// The spans continue the trace of the publisher, e.g. the upload in Django
+	ctx, span := tracer.Start(rabbitmq.ExtractTraceContext(ctx, d), "HandleMessage", trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("messaging.system", "rabbitmq"), attribute.Int("messaging.attempt", attempt)))
+	defer span.End()
 
-	if err := json.Unmarshal(d.Body, &task); err != nil {
-		vc.logError(task, "Failed to deserialize message", err)
-		d.Ack(false)
+	// Mensagens que este worker não entende ficam na parking queue até serem reprocessadas
+	contract := contracts.FromHeaders(d.Headers, contracts.ConversionRequestedV1)
+	span.SetAttributes(attribute.String("messaging.message.type", contract.String()))
+	if contract != contracts.ConversionRequestedV1 {
+		vc.park(d, contract)
 		return
 	}
 
-	// Check if the video has already been processed
-	if IsProcessed(vc.db, task.VideoID) {
-		slog.Warn("Video already processed", slog.Int("video_id", task.VideoID))
-		d.Ack(false)
+	err := contracts.Validate(contract, d.Body)
+	if err == nil {
+		err = json.Unmarshal(d.Body, &task)
+	}
+	if err != nil {
+		err = Permanent(failure("", CodeInvalidMessage, err))
+		vc.logError(task, attempt, "Failed to deserialize message", err)
+		endStep(span, err)
+		vc.retry(d, task, err)
+		return
+	}
+	span.SetAttributes(attribute.Int("video.id", task.VideoID), attribute.Bool("video.force", task.Force))
+
+	// Claim the job, skipping videos already processed or running on another worker
+	_, claimSpan := tracer.Start(ctx, "claim")
+	job, claimed, err := vc.store.ClaimJob(task, vc.workerID, vc.leaseDuration)
+	endStep(claimSpan, err)
+	if err != nil {
+		err = failure("", CodeClaimFailed, err)
+		vc.logError(task, attempt, "Failed to claim conversion job", err)
+		vc.retry(d, task, err)
 		return
 	}
+	if !claimed {
+		slog.Warn("Video conversion job not claimable, skipping", slog.Int("video_id", task.VideoID),
+			slog.String("state", string(job.State)), slog.String("worker_id", job.WorkerID))
+		ack(d)
+		return
+	}
+	slog.Info("Claimed conversion job", slog.Int("video_id", task.VideoID), slog.Int("attempts", job.Attempts), slog.Bool("force", task.Force))
+
+	jobsInFlight.Inc()
+	defer jobsInFlight.Dec()
 
-	// Process the video
-	err := vc.processVideo(&task)
+	// Process the video while a heartbeat keeps the lease
+	runCtx, cancelRun := context.WithCancelCause(ctx)
+	go vc.keepLease(runCtx, task.VideoID, cancelRun)
+	result, err := vc.processVideo(runCtx, &task, vc.newProgressReporter(ctx, task.VideoID, conversionExch))
+	cancelRun(nil)
+	if err != nil && ctx.Err() != nil {
+		slog.Warn("Video conversion canceled, requeuing", slog.Int("video_id", task.VideoID), slog.String("error", err.Error()))
+		vc.releaseJob(task, JobQueued, err)
+		d.Nack(false, true)
+		return
+	}
+	if err != nil && errors.Is(context.Cause(runCtx), ErrJobCancelled) {
+		// O operador cancelou o job, a saída parcial já foi removida
+		slog.Warn("Video conversion cancelled by an operator", slog.Int("video_id", task.VideoID))
+		messagesFailed.WithLabelValues("cancelled").Inc()
+		ack(d)
+		return
+	}
+	if err != nil && errors.Is(context.Cause(runCtx), ErrLeaseLost) {
+		// Outro worker assumiu o job, a entrega dele é que segue
+		slog.Warn("Video conversion taken over by another worker", slog.Int("video_id", task.VideoID))
+		messagesFailed.WithLabelValues("lease_lost").Inc()
+		ack(d)
+		return
+	}
 	if err != nil {
-		vc.logError(task, "Error during video conversion", err)
-		d.Ack(false)
+		vc.fail(ctx, d, task, attempt, conversionExch, "Error during video conversion", err)
 		return
 	}
 	slog.Info("Video conversion processed", slog.Int("video_id", task.VideoID))
 
-	// Mark as processed
-	err = MarkProcessed(vc.db, task.VideoID)
+	// Marcar como processado e registrar a confirmação na mesma transação
+	confirmationMessage, err := contracts.Marshal(contracts.ConversionCompletedV1, result)
 	if err != nil {
-		vc.logError(task, "Failed to mark video as processed", err)
+		vc.fail(ctx, d, task, attempt, conversionExch, "Invalid conversion result", Permanent(failure(PhasePackaging, CodePublishFailed, err)))
+		return
+	}
+	markCtx, markSpan := tracer.Start(ctx, "mark")
+	confirmation := newOutboxMessage(markCtx, task.VideoID, conversionExch, confirmationKey, confirmationQueue, contracts.ConversionCompletedV1, confirmationMessage)
+	err = vc.store.MarkProcessed(task.VideoID, vc.workerID, confirmation)
+	endStep(markSpan, err)
+	if errors.Is(err, ErrLeaseLost) && vc.leaseLostCause(task.VideoID) == ErrJobCancelled {
+		// O job foi cancelado no fim da conversão, a saída nova não vale
+		slog.Warn("Video conversion cancelled by an operator, discarding result", slog.Int("video_id", task.VideoID))
+		discardOutput(task.VideoID, filepath.Join(vc.rootPath, fmt.Sprintf("%d", task.VideoID)), result.archiveDir)
+		messagesFailed.WithLabelValues("cancelled").Inc()
+		ack(d)
+		return
+	}
+	if errors.Is(err, ErrLeaseLost) {
+		// Outro worker assumiu o job durante a conversão, os arquivos são dele
+		slog.Warn("Video conversion taken over by another worker, discarding result", slog.Int("video_id", task.VideoID))
+		messagesFailed.WithLabelValues("lease_lost").Inc()
+		ack(d)
+		return
 	}
-	d.Ack(false)
+	if err != nil {
+		vc.fail(ctx, d, task, attempt, conversionExch, "Failed to mark video as processed", failure(PhasePackaging, CodeMarkFailed, err))
+		return
+	}
+	ack(d)
+	vc.relay.Notify()
 	slog.Info("Video marked as processed", slog.Int("video_id", task.VideoID))
+}
+
+// fail records a failed attempt, hands the delivery to the retry policy, moves the running job to queued
+// or failed accordingly and notifies Django
+func (vc *VideoConverter) fail(ctx context.Context, d amqp.Delivery, task VideoTask, attempt int, exchange, message string, err error) {
+	trace.SpanFromContext(ctx).SetStatus(codes.Error, err.Error())
+	vc.logError(task, attempt, message, err)
+	willRetry := vc.retry(d, task, err)
+	if willRetry {
+		vc.releaseJob(task, JobQueued, err)
+	} else {
+		vc.releaseJob(task, JobFailed, err)
+	}
+	vc.publishFailure(ctx, exchange, task, attempt, err, willRetry)
+}
+
+// retry hands a failed delivery to the retry policy: it is redelivered after a backoff when the error
+// is retryable and attempts remain, otherwise it is dead-lettered. Without a queue name it is just acked.
+// It reports whether the job will be attempted again.
+func (vc *VideoConverter) retry(d amqp.Delivery, task VideoTask, err error) bool {
+	if vc.queueName == "" {
+		ack(d)
+		return false
+	}
+
+	dead, retryErr := vc.rabbitClient.Retry(d, vc.queueName, vc.retryPolicy, IsRetryable(err), err.Error())
+	if retryErr != nil {
+		// Sem o republish a mensagem volta para a fila, para não perder o job
+		slog.Error("Failed to schedule retry, requeuing", slog.Int("video_id", task.VideoID), slog.String("error", retryErr.Error()))
+		d.Nack(false, true)
+		return true
+	}
+	if dead {
+		slog.Error("Video conversion abandoned", slog.Int("video_id", task.VideoID), slog.String("error", err.Error()))
+	}
+	return !dead
+}
 
-	// Publicar a mensagem de confirmação
-	confirmationMessage := []byte(fmt.Sprintf(`{"video_id": %d, "path":"%s"}`, task.VideoID, task.Path))
-	err = vc.rabbitClient.PublishMessage(conversionExch, confirmationKey, confirmationQueue, confirmationMessage)
+// park moves a message of an unknown contract to the parking queue. Without a queue name it is rejected,
+// so that the broker dead-letters it when the queue has a dead-letter exchange.
+func (vc *VideoConverter) park(d amqp.Delivery, contract contracts.Contract) {
+	reason := fmt.Sprintf("%v %s", contracts.ErrUnknownContract, contract)
+	if vc.queueName == "" {
+		slog.Error("Rejecting message of unknown contract", slog.String("contract", contract.String()))
+		d.Nack(false, false)
+		return
+	}
+	if err := vc.rabbitClient.Park(d, vc.queueName, reason); err != nil {
+		slog.Error("Failed to park message, requeuing", slog.String("contract", contract.String()), slog.String("error", err.Error()))
+		d.Nack(false, true)
+	}
+}
+
+// releaseJob moves the running job of the video to queued, waiting for a retry, or to failed
+func (vc *VideoConverter) releaseJob(task VideoTask, to JobState, err error) {
+	if err := vc.store.ReleaseJob(task.VideoID, vc.workerID, to, err.Error()); err != nil {
+		slog.Warn("Failed to release conversion job", slog.Int("video_id", task.VideoID), slog.String("state", string(to)), slog.String("error", err.Error()))
+	}
+}
+
+// startPhase records the phase of the running job, the conversion goes on if it cannot be stored
+func (vc *VideoConverter) startPhase(videoID int, phase Phase) {
+	if err := vc.store.StartPhase(videoID, phase); err != nil {
+		slog.Warn("Failed to record job phase", slog.Int("video_id", videoID), slog.String("phase", string(phase)), slog.String("error", err.Error()))
+	}
+}
+
+// publishFailure notifies Django that an attempt to convert the video failed
+func (vc *VideoConverter) publishFailure(ctx context.Context, exchange string, task VideoTask, attempt int, err error, willRetry bool) {
+	if vc.failureKey == "" {
+		return
+	}
+
+	phase, code := classify(err)
+	message, marshalErr := contracts.Marshal(contracts.ConversionFailedV1, ConversionFailure{
+		VideoID:   task.VideoID,
+		Phase:     phase,
+		Code:      code,
+		Message:   code.Message(),
+		Detail:    err.Error(),
+		Attempt:   attempt,
+		Retryable: IsRetryable(err),
+		WillRetry: willRetry,
+		Timestamp: time.Now(),
+	})
+	if marshalErr != nil {
+		slog.Error("Invalid failure message", slog.Int("video_id", task.VideoID), slog.String("error", marshalErr.Error()))
+		return
+	}
+	err = vc.rabbitClient.PublishMessage(ctx, exchange, vc.failureKey, vc.failureQueue, message, contracts.ConversionFailedV1.Headers())
 	if err != nil {
-		slog.Error("Failed to publish confirmation message", slog.String("error", err.Error()))
+		slog.Error("Failed to publish failure message", slog.Int("video_id", task.VideoID), slog.String("error", err.Error()))
+		return
 	}
-	slog.Info("Published confirmation message", slog.Int("video_id", task.VideoID))
+	slog.Info("Published failure message", slog.Int("video_id", task.VideoID), slog.String("code", string(code)))
 }
 
-// processVideo handles video processing (merging chunks and converting)
-func (vc *VideoConverter) processVideo(task *VideoTask) error {
+// newProgressReporter returns the reporter of the video, or nil when progress events are disabled.
+// The events carry the trace context of ctx.
+func (vc *VideoConverter) newProgressReporter(ctx context.Context, videoID int, exchange string) *progressReporter {
+	if vc.progressKey == "" {
+		return nil
+	}
+	return &progressReporter{
+		ctx:        ctx,
+		publisher:  vc.rabbitClient,
+		exchange:   exchange,
+		routingKey: vc.progressKey,
+		queueName:  vc.progressQueue,
+		videoID:    videoID,
+		interval:   vc.progressInterval,
+		now:        time.Now,
+	}
+}
+
+// processVideo handles video processing (merging chunks and converting).
+// On failure, including a cancel, the output is discarded, unless the job was taken over by another worker.
+func (vc *VideoConverter) processVideo(ctx context.Context, task *VideoTask, progress *progressReporter) (result *ConversionResult, err error) {
 	chunkPath := filepath.Join(vc.rootPath, fmt.Sprintf("%d", task.VideoID))
 	mergedFile := filepath.Join(chunkPath, "merged.mp4")
 	mpegDashPath := filepath.Join(chunkPath, "mpeg-dash")
 
+	// A forced conversion keeps the previous output aside instead of overwriting it,
+	// before the cleanup below could remove it
+	var archiveDir string
+	if task.Force {
+		archiveDir, err = archiveOutput(chunkPath, time.Now())
+		if err != nil {
+			return nil, failure(PhaseMerging, CodeStorageFailed, fmt.Errorf("failed to archive previous output: %v", err))
+		}
+		if archiveDir != "" {
+			slog.Info("Archived previous output", slog.Int("video_id", task.VideoID), slog.String("path", archiveDir))
+		}
+	}
+
+	defer func() {
+		// Depois de perder o lease os arquivos pertencem ao worker que assumiu o job
+		if err != nil && !errors.Is(context.Cause(ctx), ErrLeaseLost) {
+			discardOutput(task.VideoID, chunkPath, archiveDir)
+		}
+	}()
+
 	// Merge chunks
 	slog.Info("Merging chunks", slog.String("path", chunkPath))
-	if err := vc.mergeChunks(chunkPath, mergedFile); err != nil {
-		return fmt.Errorf("failed to merge chunks: %v", err)
+	vc.startPhase(task.VideoID, PhaseMerging)
+	progress.Report(PhaseMerging, 0, -1)
+	start := time.Now()
+	_, mergeSpan := tracer.Start(ctx, "merge")
+	err = vc.mergeChunks(chunkPath, mergedFile)
+	endStep(mergeSpan, err)
+	observeStep(stepMerge, start)
+	if err != nil {
+		return nil, failure(PhaseMerging, CodeMergeFailed, fmt.Errorf("failed to merge chunks: %v", err))
 	}
+	progress.Report(PhaseMerging, 100, 0)
 
 	// Create directory for MPEG-DASH output
 	if err := os.MkdirAll(mpegDashPath, os.ModePerm); err != nil {
-		return fmt.Errorf("failed to create output directory: %v", err)
+		return nil, failure(PhaseMerging, CodeStorageFailed, fmt.Errorf("failed to create output directory: %v", err))
 	}
 
-	// Convert to MPEG-DASH
-	ffmpegCmd := exec.Command(
-		"ffmpeg", "-i", mergedFile, // Arquivo de entrada
-		"-f", "dash", // Formato de saída
-		filepath.Join(mpegDashPath, "output.mpd"), // Caminho para salvar o arquivo .mpd
-	)
+	// Probe and validate the merged file before encoding it
+	vc.startPhase(task.VideoID, PhaseProbing)
+	progress.Report(PhaseProbing, 0, -1)
+	start = time.Now()
+	probeCtx, probeSpan := tracer.Start(ctx, "probe")
+	source, err := vc.transcoder.Probe(probeCtx, mergedFile)
+	if err == nil {
+		err = source.Validate()
+	}
+	endStep(probeSpan, err)
+	observeStep(stepProbe, start)
+	if err != nil {
+		code := CodeProbeFailed
+		if !IsRetryable(err) {
+			code = CodeProbeInvalid
+		}
+		return nil, failure(PhaseProbing, code, fmt.Errorf("failed to probe merged file: %w", err))
+	}
+	progress.Report(PhaseProbing, 100, 0)
+	slog.Info("Probed merged file", slog.Int("video_id", task.VideoID), slog.Float64("duration", source.Duration),
+		slog.String("video_codec", source.VideoCodec), slog.Int("width", source.Width), slog.Int("height", source.Height))
+
+	if err := vc.store.SaveMetadata(task.VideoID, source); err != nil {
+		slog.Warn("Failed to store video metadata", slog.Int("video_id", task.VideoID), slog.String("error", err.Error()))
+	}
 
-	output, err := ffmpegCmd.CombinedOutput()
+	// Select the renditions that fit the source resolution
+	renditions := vc.ladder.ForSource(source.DisplaySize())
+
+	// Encoding and thumbnails are bounded by the job timeout, scaled by the input duration
+	timeout := vc.jobTimeout(source.Duration)
+	jobCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Convert to MPEG-DASH and HLS, one Representation per rendition
+	vc.startPhase(task.VideoID, PhaseEncoding)
+	start = time.Now()
+	transcodeCtx, transcodeSpan := tracer.Start(jobCtx, "transcode", trace.WithAttributes(attribute.String("video.renditions", renditionNames(renditions))))
+	err = vc.transcoder.Transcode(transcodeCtx, TranscodeJob{
+		InputFile:  mergedFile,
+		OutputDir:  mpegDashPath,
+		Renditions: renditions,
+		Source:     *source,
+		Progress: func(p TranscodeProgress) {
+			progress.ReportEncoding(p, source.Duration)
+		},
+	})
+	endStep(transcodeSpan, err)
+	encodeTime := time.Since(start)
+	stepDuration.WithLabelValues(stepEncode).Observe(encodeTime.Seconds())
 	if err != nil {
-		return fmt.Errorf("failed to convert to MPEG-DASH: %v, output: %s", err, string(output))
+		if ctx.Err() == nil && jobCtx.Err() != nil {
+			return nil, failure(PhaseEncoding, CodeEncodeTimeout, fmt.Errorf("failed to convert to MPEG-DASH after %s: %w", timeout, ErrJobTimeout))
+		}
+		return nil, failure(PhaseEncoding, CodeEncodeFailed, fmt.Errorf("failed to convert to MPEG-DASH: %w", err))
+	}
+	slog.Info("Converted to MPEG-DASH and HLS", slog.String("path", mpegDashPath), slog.String("renditions", renditionNames(renditions)))
+	if encodeTime > 0 {
+		encodeSpeed.Observe(source.Duration / encodeTime.Seconds())
+	}
+	if size, err := dirSize(mpegDashPath); err == nil {
+		outputBytes.Observe(float64(size))
+	}
+
+	// Thumbnails are optional, a failure here does not invalidate the conversion
+	vc.startPhase(task.VideoID, PhasePackaging)
+	progress.Report(PhasePackaging, 0, -1)
+	start = time.Now()
+	thumbnailsCtx, thumbnailsSpan := tracer.Start(jobCtx, "thumbnails")
+	thumbnails, thumbErr := vc.generateThumbnails(thumbnailsCtx, mergedFile, chunkPath, task.Path, source)
+	endStep(thumbnailsSpan, thumbErr)
+	observeStep(stepThumbnails, start)
+	if thumbErr != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		slog.Warn("Failed to generate thumbnails", slog.Int("video_id", task.VideoID), slog.String("error", thumbErr.Error()))
 	}
-	slog.Info("Converted to MPEG-DASH", slog.String("path", mpegDashPath))
+	progress.Report(PhasePackaging, 100, 0)
 
 	// Remove merged file after processing
 	if err := os.Remove(mergedFile); err != nil {
@@ -118,6 +544,91 @@ func (vc *VideoConverter) processVideo(task *VideoTask) error {
 	}
 	slog.Info("Removed merged file", slog.String("file", mergedFile))
 
+	return &ConversionResult{
+		VideoID:      task.VideoID,
+		Path:         task.Path,
+		DashManifest: filepath.Join(task.Path, "mpeg-dash", dashManifestName),
+		HLSManifest:  filepath.Join(task.Path, "mpeg-dash", hlsManifestName),
+		Metadata:     source,
+		Thumbnails:   thumbnails,
+		archiveDir:   archiveDir,
+	}, nil
+}
+
+// discardOutput removes the merged file and the output folders of a conversion, leaving only the chunks,
+// and puts back the output archived by a forced conversion, if any
+func discardOutput(videoID int, videoDir, archiveDir string) {
+	removePartialOutput(videoDir)
+	if archiveDir == "" {
+		return
+	}
+	if err := restoreOutput(videoDir, archiveDir); err != nil {
+		slog.Error("Failed to restore previous output", slog.Int("video_id", videoID), slog.String("path", archiveDir),
+			slog.String("error", err.Error()))
+		return
+	}
+	slog.Info("Restored previous output", slog.Int("video_id", videoID), slog.String("path", archiveDir))
+}
+
+// removePartialOutput deletes the merged file and the output folders of a failed conversion
+func removePartialOutput(videoDir string) {
+	for _, name := range []string{"merged.mp4", "mpeg-dash", thumbnailsDirName} {
+		if err := os.RemoveAll(filepath.Join(videoDir, name)); err != nil {
+			slog.Warn("Failed to remove partial output", slog.String("path", filepath.Join(videoDir, name)), slog.String("error", err.Error()))
+		}
+	}
+}
+
+// archiveOutput moves the output folders of a previous conversion to archive/<timestamp>, so that a new
+// encoding starts clean while the old one stays available. It returns the archive folder, empty when
+// there was nothing to archive.
+func archiveOutput(videoDir string, now time.Time) (string, error) {
+	archiveDir := filepath.Join(videoDir, "archive", now.UTC().Format("20060102T150405Z"))
+	archived := false
+	for _, name := range []string{"mpeg-dash", thumbnailsDirName} {
+		source := filepath.Join(videoDir, name)
+		if _, err := os.Stat(source); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.MkdirAll(archiveDir, os.ModePerm); err != nil {
+			return "", err
+		}
+		if err := os.Rename(source, filepath.Join(archiveDir, name)); err != nil {
+			// Não deixa a saída pela metade no arquivo
+			if archived {
+				restoreOutput(videoDir, archiveDir)
+			}
+			return "", err
+		}
+		archived = true
+	}
+	if !archived {
+		return "", nil
+	}
+	return archiveDir, nil
+}
+
+// restoreOutput moves the output folders archived by archiveOutput back in place, replacing those of the
+// failed conversion, and removes the archive folder
+func restoreOutput(videoDir, archiveDir string) error {
+	for _, name := range []string{"mpeg-dash", thumbnailsDirName} {
+		source := filepath.Join(archiveDir, name)
+		if _, err := os.Stat(source); os.IsNotExist(err) {
+			continue
+		}
+		target := filepath.Join(videoDir, name)
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		if err := os.Rename(source, target); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(archiveDir); err != nil {
+		return err
+	}
+	// A pasta archive só fica se guardar outras conversões
+	os.Remove(filepath.Dir(archiveDir))
 	return nil
 }
 
@@ -166,17 +677,15 @@ func (vc *VideoConverter) mergeChunks(inputDir, outputFile string) error {
 	return nil
 }
 
-// logError handles logging the error in JSON format
-func (vc *VideoConverter) logError(task VideoTask, message string, err error) {
-	errorData := map[string]interface{}{
-		"video_id": task.VideoID,
-		"error":    message,
-		"details":  err.Error(),
-		"time":     time.Now(),
-	}
+// logError handles logging the error in JSON format and storing it in the error log
+func (vc *VideoConverter) logError(task VideoTask, attempt int, message string, err error) {
+	record := newErrorRecord(task.VideoID, attempt, message, err)
+	messagesFailed.WithLabelValues(string(record.Code)).Inc()
 
-	serializedError, _ := json.Marshal(errorData)
+	serializedError, _ := json.Marshal(record)
 	slog.Error("Processing error", slog.String("error_details", string(serializedError)))
 
-	RegisterError(vc.db, errorData, err)
+	if err := vc.store.RegisterError(record); err != nil {
+		slog.Error("Error storing error log in database", slog.Int("video_id", task.VideoID), slog.String("error", err.Error()))
+	}
 }
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"imersaofc/internal/converter"
+	"imersaofc/pkg/contracts"
 	"imersaofc/pkg/rabbitmq"
 	"os"
 	"path/filepath"
@@ -50,7 +51,8 @@ func TestTaskProcessing(t *testing.T) {
 
 	// Set up the video converter
 	rootPath := "../../mediatest/media/uploads"
-	videoConverter := converter.NewVideoConverter(rabbitClient, db, rootPath)
+	store := converter.NewPostgresStore(db)
+	videoConverter := converter.NewVideoConverter(rabbitClient, store, converter.NewFFmpegTranscoder(), converter.Config{RootPath: rootPath})
 
 	// Prepare the message for conversion
 	videoTask := converter.VideoTask{
@@ -66,10 +68,15 @@ func TestTaskProcessing(t *testing.T) {
 	assert.NoError(t, err, "Failed to consume messages from video conversion queue")
 
 	// Publish the message to the conversion queue
-	err = rabbitClient.PublishMessage(exchangeName, conversionKey, conversionQueue, taskMessage)
+	err = rabbitClient.PublishMessage(ctx, exchangeName, conversionKey, conversionQueue, taskMessage, contracts.ConversionRequestedV1.Headers())
 	assert.NoError(t, err, "Failed to publish message to conversion queue")
 
-	videoConverter.HandleMessage(ctx, <-msgs)
+	videoConverter.HandleMessage(ctx, <-msgs, exchangeName, finishConversionKey, finishConversionQueue)
+
+	// O relay publica a confirmação gravada no outbox
+	sent, err := converter.NewOutboxRelay(store, rabbitClient, time.Second).RelayPending(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sent)
 
 	// Publicar a mensagem de confirmação
 	confirmationTask := converter.VideoTask{
@@ -77,7 +84,7 @@ func TestTaskProcessing(t *testing.T) {
 		Path:    expectedPath,
 	}
 	confirmationMessage, _ := json.Marshal(confirmationTask)
-	err = rabbitClient.PublishMessage(exchangeName, finishConversionKey, finishConversionQueue, confirmationMessage)
+	err = rabbitClient.PublishMessage(ctx, exchangeName, finishConversionKey, finishConversionQueue, confirmationMessage, nil)
 	assert.NoError(t, err, "Failed to publish message to confirmation queue")
 
 	// Check if the output file was created
@@ -105,7 +112,7 @@ func TestTaskProcessing(t *testing.T) {
 
 	// Verify that the video was marked as processed in the database
 	var processed bool
-	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM processed_videos WHERE video_id = $1 AND status = 'success')", videoTask.VideoID).Scan(&processed)
+	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM conversion_jobs WHERE video_id = $1 AND state = 'succeeded')", videoTask.VideoID).Scan(&processed)
 	assert.NoError(t, err)
 	assert.True(t, processed, "Video was not marked as processed in the database")
 
@@ -0,0 +1,167 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	thumbnailsDirName = "thumbnails"     // Folder next to mpeg-dash/ holding the generated images
+	posterName        = "poster.jpg"     // Frame used as the default thumbnail
+	spriteTrackName   = "thumbnails.vtt" // WebVTT track pointing to the sprite tiles
+	spritePattern     = "sprite-%03d.jpg"
+	thumbnailWidth    = 640 // Width of the poster and candidate thumbnails
+	spriteTileWidth   = 160
+	spriteTileHeight  = 90
+	spriteColumns     = 10
+	spriteRows        = 10
+	maxSpriteFrames   = 300 // Upper bound of scrubbing previews per video
+)
+
+// thumbnailOffsets are the positions, as fractions of the duration, of the candidate thumbnails
+var thumbnailOffsets = []float64{0.1, 0.3, 0.5, 0.7, 0.9}
+
+// ThumbnailSet lists the images generated for a video
+type ThumbnailSet struct {
+	Poster      string   `json:"poster"`
+	Thumbnails  []string `json:"thumbnails"`
+	SpriteTrack string   `json:"sprite_track"`
+	Sprites     []string `json:"sprites"`
+}
+
+// FrameCapture is a single frame written as a JPEG image
+type FrameCapture struct {
+	At   float64 // Seconds from the start of the video
+	File string
+}
+
+// SpriteSpec describes the sprite sheets holding the scrubbing previews
+type SpriteSpec struct {
+	Interval   float64 // Seconds between two previews
+	Columns    int
+	Rows       int
+	TileWidth  int
+	TileHeight int
+	Pattern    string // Printf pattern of the sheet files, numbered from zero
+	Sheets     int    // Number of sheets the previews fill
+}
+
+// ThumbnailJob describes the images extracted from a video
+type ThumbnailJob struct {
+	InputFile  string
+	Frames     []FrameCapture
+	FrameWidth int
+	Sprite     SpriteSpec
+}
+
+// planThumbnails decides which frames and sprite sheets are extracted from a video of the given duration
+func planThumbnails(inputFile, outputDir string, duration float64) ThumbnailJob {
+	job := ThumbnailJob{
+		InputFile:  inputFile,
+		Frames:     []FrameCapture{{At: duration * thumbnailOffsets[0], File: filepath.Join(outputDir, posterName)}},
+		FrameWidth: thumbnailWidth,
+	}
+	for i, offset := range thumbnailOffsets {
+		job.Frames = append(job.Frames, FrameCapture{
+			At:   duration * offset,
+			File: filepath.Join(outputDir, fmt.Sprintf("thumbnail-%02d.jpg", i+1)),
+		})
+	}
+
+	interval := math.Max(2, math.Ceil(duration/maxSpriteFrames))
+	frames := int(math.Ceil(duration / interval))
+	perSheet := spriteColumns * spriteRows
+	job.Sprite = SpriteSpec{
+		Interval:   interval,
+		Columns:    spriteColumns,
+		Rows:       spriteRows,
+		TileWidth:  spriteTileWidth,
+		TileHeight: spriteTileHeight,
+		Pattern:    filepath.Join(outputDir, spritePattern),
+		Sheets:     (frames + perSheet - 1) / perSheet,
+	}
+	return job
+}
+
+// writeSpriteTrack writes the WebVTT track mapping each interval of the video to its tile in the sprite sheets
+func writeSpriteTrack(w io.Writer, duration float64, spec SpriteSpec) error {
+	if _, err := io.WriteString(w, "WEBVTT\n"); err != nil {
+		return err
+	}
+
+	perSheet := spec.Columns * spec.Rows
+	for i := 0; float64(i)*spec.Interval < duration; i++ {
+		start := float64(i) * spec.Interval
+		end := math.Min(start+spec.Interval, duration)
+		pos := i % perSheet
+		sheet := filepath.Base(fmt.Sprintf(spec.Pattern, i/perSheet))
+
+		_, err := fmt.Fprintf(w, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end), sheet,
+			(pos%spec.Columns)*spec.TileWidth, (pos/spec.Columns)*spec.TileHeight, spec.TileWidth, spec.TileHeight)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vttTimestamp formats seconds as a WebVTT timestamp (HH:MM:SS.mmm)
+func vttTimestamp(seconds float64) string {
+	ms := int(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// generateThumbnails extracts the poster, the candidate thumbnails and the sprite sheets into
+// the thumbnails folder of the video and returns their paths under publicPath
+func (vc *VideoConverter) generateThumbnails(ctx context.Context, mergedFile, videoDir, publicPath string, source *MediaInfo) (*ThumbnailSet, error) {
+	outputDir := filepath.Join(videoDir, thumbnailsDirName)
+	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create thumbnails directory: %v", err)
+	}
+
+	job := planThumbnails(mergedFile, outputDir, source.Duration)
+	if err := vc.transcoder.Thumbnails(ctx, job); err != nil {
+		return nil, fmt.Errorf("failed to extract thumbnails: %v", err)
+	}
+
+	track, err := os.Create(filepath.Join(outputDir, spriteTrackName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sprite track: %v", err)
+	}
+	defer track.Close()
+	if err := writeSpriteTrack(track, source.Duration, job.Sprite); err != nil {
+		return nil, fmt.Errorf("failed to write sprite track: %v", err)
+	}
+
+	// Os caminhos publicados são relativos ao diretório do vídeo no storage
+	public := func(file string) string {
+		return filepath.Join(publicPath, thumbnailsDirName, filepath.Base(file))
+	}
+	set := &ThumbnailSet{
+		Poster:      public(job.Frames[0].File),
+		SpriteTrack: public(spriteTrackName),
+	}
+	for _, frame := range job.Frames[1:] {
+		set.Thumbnails = append(set.Thumbnails, public(frame.File))
+	}
+	for i := 0; i < job.Sprite.Sheets; i++ {
+		set.Sprites = append(set.Sprites, public(fmt.Sprintf(spritePattern, i)))
+	}
+	return set, nil
+}
+
+// spriteFilter builds the ffmpeg filter that samples, scales and tiles the previews of a sprite sheet
+func spriteFilter(spec SpriteSpec) string {
+	return strings.Join([]string{
+		fmt.Sprintf("fps=1/%g", spec.Interval),
+		fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", spec.TileWidth, spec.TileHeight),
+		fmt.Sprintf("pad=%d:%d:(ow-iw)/2:(oh-ih)/2", spec.TileWidth, spec.TileHeight),
+		fmt.Sprintf("tile=%dx%d", spec.Columns, spec.Rows),
+	}, ",")
+}
@@ -0,0 +1,49 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanThumbnails(t *testing.T) {
+	job := planThumbnails("merged.mp4", "thumbs", 1000)
+
+	// Poster + 5 candidatos
+	assert.Len(t, job.Frames, 6)
+	assert.Equal(t, "thumbs/poster.jpg", job.Frames[0].File)
+	assert.Equal(t, 900.0, job.Frames[5].At)
+
+	// 1000s are sampled every 4s (250 previews), filling 3 sheets of 10x10 tiles
+	assert.Equal(t, 4.0, job.Sprite.Interval)
+	assert.Equal(t, 3, job.Sprite.Sheets)
+}
+
+func TestWriteSpriteTrack(t *testing.T) {
+	spec := SpriteSpec{Interval: 2, Columns: 2, Rows: 2, TileWidth: 160, TileHeight: 90, Pattern: "thumbs/sprite-%03d.jpg"}
+
+	var track strings.Builder
+	assert.NoError(t, writeSpriteTrack(&track, 9.5, spec))
+	assert.Equal(t, `WEBVTT
+
+00:00:00.000 --> 00:00:02.000
+sprite-000.jpg#xywh=0,0,160,90
+
+00:00:02.000 --> 00:00:04.000
+sprite-000.jpg#xywh=160,0,160,90
+
+00:00:04.000 --> 00:00:06.000
+sprite-000.jpg#xywh=0,90,160,90
+
+00:00:06.000 --> 00:00:08.000
+sprite-000.jpg#xywh=160,90,160,90
+
+00:00:08.000 --> 00:00:09.500
+sprite-001.jpg#xywh=0,0,160,90
+`, track.String())
+}
+
+func TestVttTimestamp(t *testing.T) {
+	assert.Equal(t, "01:02:03.450", vttTimestamp(3723.45))
+}
@@ -0,0 +1,22 @@
+package converter
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	defaultTimeoutBase   = 10 * time.Minute
+	defaultTimeoutFactor = 5.0
+	defaultTimeoutMax    = 6 * time.Hour
+)
+
+// ErrJobTimeout is returned when encoding a video exceeds its maximum duration
+var ErrJobTimeout = errors.New("conversion exceeded the job timeout")
+
+// jobTimeout is the maximum duration of the encoding of a video: a fixed base plus
+// timeoutFactor seconds for each second of input, capped at timeoutMax
+func (vc *VideoConverter) jobTimeout(inputDuration float64) time.Duration {
+	timeout := vc.timeoutBase + time.Duration(inputDuration*vc.timeoutFactor*float64(time.Second))
+	return min(timeout, vc.timeoutMax)
+}
@@ -0,0 +1,19 @@
+package converter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobTimeout(t *testing.T) {
+	vc := NewVideoConverter(nil, nil, NewFakeTranscoder(), Config{})
+
+	// 10m + 5x a duração do vídeo
+	assert.Equal(t, 20*time.Minute, vc.jobTimeout(120))
+	assert.Equal(t, 10*time.Minute, vc.jobTimeout(0))
+
+	// Vídeos longos são limitados pelo máximo
+	assert.Equal(t, 6*time.Hour, vc.jobTimeout(10*3600))
+}
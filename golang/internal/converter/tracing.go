@@ -0,0 +1,19 @@
+package converter
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of HandleMessage and of each step of the conversion
+var tracer = otel.Tracer("imersaofc/internal/converter")
+
+// endStep ends the span of a step, recording the error that stopped it
+func endStep(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
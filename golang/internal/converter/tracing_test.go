@@ -0,0 +1,59 @@
+package converter_test
+
+import (
+	"context"
+	"encoding/json"
+	"imersaofc/internal/converter"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestHandleMessageTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	f := newHandlerFixture(t)
+	writeChunks(t, f.rootPath, 1)
+	expectClaim(f.mock, 1)
+	expectPhases(f.mock, 1, converter.PhaseMerging, converter.PhaseProbing)
+	f.mock.ExpectExec(saveMetadataQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPhases(f.mock, 1, converter.PhaseEncoding, converter.PhasePackaging)
+	confirmation := expectMarkProcessed(f.mock, 1)
+
+	// Mensagem publicada dentro de um trace, como o upload no Django
+	d, _ := newDelivery(t, converter.VideoTask{VideoID: 1, Path: "/media/uploads/1"})
+	d.Headers = amqp.Table{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+	f.converter.HandleMessage(context.Background(), d, "conversion_exchange", "finish-conversion", "video_confirmation_queue")
+	assert.NoError(t, f.mock.ExpectationsWereMet())
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		assert.Equal(t, traceID, span.SpanContext().TraceID(), span.Name())
+		spans[span.Name()] = span
+	}
+
+	root := spans["HandleMessage"]
+	assert.NotNil(t, root)
+	assert.Equal(t, trace.SpanKindConsumer, root.SpanKind())
+	assert.Equal(t, "00f067aa0ba902b7", root.Parent().SpanID().String())
+	for _, name := range []string{"claim", "merge", "probe", "transcode", "thumbnails", "mark"} {
+		if assert.Contains(t, spans, name) {
+			assert.Equal(t, root.SpanContext().SpanID(), spans[name].Parent().SpanID(), name)
+		}
+	}
+
+	// The confirmation queued in the outbox carries the trace on to Django
+	var headers map[string]string
+	assert.NoError(t, json.Unmarshal([]byte(confirmation.headers.value.(string)), &headers))
+	assert.Contains(t, headers["traceparent"], traceID.String())
+}
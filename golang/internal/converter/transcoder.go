@@ -0,0 +1,22 @@
+package converter
+
+import "context"
+
+// Transcoder abstracts the media toolchain used to inspect and encode the merged video
+type Transcoder interface {
+	// Probe reads the properties of the input file
+	Probe(ctx context.Context, inputFile string) (*MediaInfo, error)
+	// Transcode encodes the input file into the manifests and segments described by the job
+	Transcode(ctx context.Context, job TranscodeJob) error
+	// Thumbnails extracts the still frames and sprite sheets described by the job
+	Thumbnails(ctx context.Context, job ThumbnailJob) error
+}
+
+// TranscodeJob describes a single encoding of a video into its output directory
+type TranscodeJob struct {
+	InputFile  string
+	OutputDir  string
+	Renditions Ladder
+	Source     MediaInfo
+	Progress   func(TranscodeProgress) // Called as the encoding advances, may be nil
+}
@@ -4,8 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"io/ioutil"
-	"path/filepath"
+	"imersaofc/internal/converter"
 
 	_ "github.com/lib/pq"
 	"github.com/testcontainers/testcontainers-go"
@@ -94,14 +93,8 @@ func setupPostgresContainer(ctx context.Context) (testcontainers.Container, *sql
 		return nil, nil, err
 	}
 
-	// Execute the SQL file
-	sqlFilePath := filepath.Join("../../", "db.sql")
-	sqlContent, err := ioutil.ReadFile(sqlFilePath)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	_, err = db.Exec(string(sqlContent))
+	// Create the schema with the embedded migrations
+	_, err = converter.NewPostgresStore(db).MigrateUp(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -0,0 +1,139 @@
+// Package contracts defines the versioned messages exchanged between Django and the converter.
+// Each contract has a JSON Schema under schemas/, shared with Django, and is identified in the
+// AMQP headers of the message by its type and version.
+package contracts
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/streadway/amqp"
+)
+
+// Message types
+const (
+	ConversionRequested = "conversion.requested" // Django asks for a video to be converted
+	ConversionCompleted = "conversion.completed" // The converter reports a converted video
+	ConversionFailed    = "conversion.failed"    // The converter reports a failed attempt
+)
+
+// Headers identifying the contract of a message
+const (
+	TypeHeader    = "x-message-type"
+	VersionHeader = "x-message-version"
+)
+
+// ErrUnknownContract is returned for a type and version without a schema
+var ErrUnknownContract = errors.New("unknown message contract")
+
+// Contract is the type and version of a message
+type Contract struct {
+	Type    string
+	Version int
+}
+
+// Contracts known by this version of the converter
+var (
+	ConversionRequestedV1 = Contract{ConversionRequested, 1}
+	ConversionCompletedV1 = Contract{ConversionCompleted, 1}
+	ConversionFailedV1    = Contract{ConversionFailed, 1}
+)
+
+//go:embed schemas/*.json
+var files embed.FS
+
+var schemas = map[Contract]*jsonschema.Schema{
+	ConversionRequestedV1: mustCompile(ConversionRequestedV1),
+	ConversionCompletedV1: mustCompile(ConversionCompletedV1),
+	ConversionFailedV1:    mustCompile(ConversionFailedV1),
+}
+
+// mustCompile compiles the schema of the contract, schemas/<type>.v<version>.json
+func mustCompile(c Contract) *jsonschema.Schema {
+	name := c.String() + ".json"
+	data, err := files.ReadFile("schemas/" + name)
+	if err != nil {
+		panic(err)
+	}
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat = true
+	if err := compiler.AddResource(name, bytes.NewReader(data)); err != nil {
+		panic(err)
+	}
+	return compiler.MustCompile(name)
+}
+
+// String returns the name of the contract, e.g. conversion.requested.v1
+func (c Contract) String() string {
+	return fmt.Sprintf("%s.v%d", c.Type, c.Version)
+}
+
+// Known reports whether the contract has a schema
+func (c Contract) Known() bool {
+	_, ok := schemas[c]
+	return ok
+}
+
+// Headers returns the AMQP headers identifying the contract
+func (c Contract) Headers() amqp.Table {
+	return amqp.Table{TypeHeader: c.Type, VersionHeader: strconv.Itoa(c.Version)}
+}
+
+// FromHeaders reads the contract of a message from its headers. Messages without a type, published before
+// the contracts were versioned, get fallback. A missing version is 1, one that cannot be read is 0.
+func FromHeaders(headers amqp.Table, fallback Contract) Contract {
+	kind, ok := headers[TypeHeader].(string)
+	if !ok || kind == "" {
+		return fallback
+	}
+	contract := Contract{Type: kind, Version: 1}
+	switch version := headers[VersionHeader].(type) {
+	case nil:
+	case string:
+		contract.Version, _ = strconv.Atoi(version)
+	case int32:
+		contract.Version = int(version)
+	case int64:
+		contract.Version = int(version)
+	case int:
+		contract.Version = version
+	default:
+		contract.Version = 0
+	}
+	return contract
+}
+
+// Validate checks the payload against the schema of the contract
+func Validate(c Contract, payload []byte) error {
+	schema, ok := schemas[c]
+	if !ok {
+		return fmt.Errorf("%w %s", ErrUnknownContract, c)
+	}
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("invalid %s message: %v", c, err)
+	}
+	if err := schema.Validate(value); err != nil {
+		return fmt.Errorf("invalid %s message: %v", c, err)
+	}
+	return nil
+}
+
+// Marshal encodes v as JSON and validates it against the contract
+func Marshal(c Contract, v any) ([]byte, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := Validate(c, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
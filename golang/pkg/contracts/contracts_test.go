@@ -0,0 +1,59 @@
+package contracts_test
+
+import (
+	"testing"
+
+	"imersaofc/pkg/contracts"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromHeaders(t *testing.T) {
+	fallback := contracts.ConversionRequestedV1
+	tests := []struct {
+		name     string
+		headers  amqp.Table
+		expected contracts.Contract
+	}{
+		{"no headers", nil, fallback},
+		{"type and version", contracts.ConversionFailedV1.Headers(), contracts.ConversionFailedV1},
+		{"integer version", amqp.Table{contracts.TypeHeader: "conversion.requested", contracts.VersionHeader: int32(2)}, contracts.Contract{"conversion.requested", 2}},
+		{"missing version", amqp.Table{contracts.TypeHeader: "conversion.completed"}, contracts.ConversionCompletedV1},
+		{"unreadable version", amqp.Table{contracts.TypeHeader: "conversion.requested", contracts.VersionHeader: "v1"}, contracts.Contract{"conversion.requested", 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, contracts.FromHeaders(tt.headers, fallback))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, contracts.Validate(contracts.ConversionRequestedV1, []byte(`{"video_id": 1, "path": "/media/uploads/\"quoted\""}`)))
+	assert.NoError(t, contracts.Validate(contracts.ConversionRequestedV1, []byte(`{"video_id": 1, "path": "/media/uploads/1", "force": true}`)))
+
+	assert.ErrorContains(t, contracts.Validate(contracts.ConversionRequestedV1, []byte(`{"video_id": 1}`)), "invalid conversion.requested.v1 message")
+	assert.Error(t, contracts.Validate(contracts.ConversionRequestedV1, []byte(`{"video_id": "1", "path": "/media/uploads/1"}`)))
+	assert.Error(t, contracts.Validate(contracts.ConversionRequestedV1, []byte(`{"video_id": 1.5, "path": "/media/uploads/1"}`)))
+	assert.Error(t, contracts.Validate(contracts.ConversionRequestedV1, []byte(`not json`)))
+
+	err := contracts.Validate(contracts.Contract{"conversion.requested", 2}, []byte(`{}`))
+	assert.ErrorIs(t, err, contracts.ErrUnknownContract)
+	assert.False(t, contracts.Contract{"conversion.requested", 2}.Known())
+	assert.True(t, contracts.ConversionCompletedV1.Known())
+}
+
+func TestMarshal(t *testing.T) {
+	failure := map[string]any{
+		"video_id": 1, "phase": "encoding", "code": "encode_failed", "message": "The video could not be encoded",
+		"detail": "exit status 1", "attempt": 1, "retryable": true, "will_retry": true, "timestamp": "2024-01-01T00:00:00Z",
+	}
+	payload, err := contracts.Marshal(contracts.ConversionFailedV1, failure)
+	assert.NoError(t, err)
+	assert.Contains(t, string(payload), `"code":"encode_failed"`)
+
+	failure["timestamp"] = "yesterday"
+	_, err = contracts.Marshal(contracts.ConversionFailedV1, failure)
+	assert.ErrorContains(t, err, "invalid conversion.failed.v1 message")
+}
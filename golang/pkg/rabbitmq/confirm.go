@@ -0,0 +1,182 @@
+package rabbitmq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// DefaultConfirmTimeout is how long a publish waits for the broker to confirm it
+const DefaultConfirmTimeout = 5 * time.Second
+
+// Errors of a publish the broker did not confirm, the message may not have reached a queue
+var (
+	ErrPublishNacked  = errors.New("message nacked by the broker")
+	ErrUnroutable     = errors.New("message returned as unroutable")
+	ErrConfirmTimeout = errors.New("timed out waiting for the publish confirmation")
+	ErrChannelClosed  = errors.New("channel closed before the publish was confirmed")
+)
+
+// pendingPublish is a message waiting for its confirmation
+type pendingPublish struct {
+	messageID string
+	returned  *amqp.Return // Set when the broker could not route the message
+	done      chan error
+}
+
+// confirmer publishes on a channel in confirm mode, matching the acks, nacks and returns of the
+// broker to the publishes waiting for them. The delivery tags are counted in the order of the publishes,
+// which is why publish serializes them.
+type confirmer struct {
+	channel *amqp.Channel
+	mu      sync.Mutex
+	nextTag uint64
+	pending map[uint64]*pendingPublish
+	closed  bool
+}
+
+// newConfirmer puts the channel in confirm mode
+func newConfirmer(channel *amqp.Channel) (*confirmer, error) {
+	if err := channel.Confirm(false); err != nil {
+		return nil, fmt.Errorf("failed to enable publisher confirms: %v", err)
+	}
+	c := &confirmer{channel: channel, pending: map[uint64]*pendingPublish{}}
+	confirms := channel.NotifyPublish(make(chan amqp.Confirmation, 64))
+	returns := channel.NotifyReturn(make(chan amqp.Return, 64))
+	go c.listen(confirms, returns)
+	return c, nil
+}
+
+// publish publishes a mandatory message and waits until the broker confirms it was routed to a queue
+func (c *confirmer) publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing, timeout time.Duration) error {
+	if msg.MessageId == "" {
+		msg.MessageId = newMessageID()
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrChannelClosed
+	}
+	if err := c.channel.Publish(exchange, routingKey, true, false, msg); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.nextTag++
+	tag := c.nextTag
+	done := make(chan error, 1)
+	c.pending[tag] = &pendingPublish{messageID: msg.MessageId, done: done}
+	c.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		c.forget(tag)
+		return fmt.Errorf("%w after %s", ErrConfirmTimeout, timeout)
+	case <-ctx.Done():
+		c.forget(tag)
+		return ctx.Err()
+	}
+}
+
+func (c *confirmer) forget(tag uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, tag)
+}
+
+// listen settles the pending publishes until the channel is closed. The broker sends the return of an
+// unroutable message before its ack and the client queues both from the same goroutine, so the return is
+// already in returns when the ack arrives. Both channels may be ready at once though, and select picks one
+// at random, so the queued returns are recorded before each confirmation is settled.
+func (c *confirmer) listen(confirms <-chan amqp.Confirmation, returns <-chan amqp.Return) {
+	for {
+		select {
+		case r, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			c.returned(r)
+		case confirmation, ok := <-confirms:
+			if !ok {
+				c.close()
+				return
+			}
+			returns = c.drainReturns(returns)
+			c.confirm(confirmation)
+		}
+	}
+}
+
+// drainReturns records the returns already queued without waiting for more. It returns nil once returns
+// is closed, so listen stops selecting on it.
+func (c *confirmer) drainReturns(returns <-chan amqp.Return) <-chan amqp.Return {
+	for {
+		select {
+		case r, ok := <-returns:
+			if !ok {
+				return nil
+			}
+			c.returned(r)
+		default:
+			return returns
+		}
+	}
+}
+
+func (c *confirmer) returned(r amqp.Return) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range c.pending {
+		if p.messageID == r.MessageId {
+			p.returned = &r
+			return
+		}
+	}
+}
+
+func (c *confirmer) confirm(confirmation amqp.Confirmation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pending[confirmation.DeliveryTag]
+	if !ok {
+		return // A publish that gave up waiting
+	}
+	delete(c.pending, confirmation.DeliveryTag)
+	switch {
+	case !confirmation.Ack:
+		p.done <- ErrPublishNacked
+	case p.returned != nil:
+		p.done <- fmt.Errorf("%w: %d %s", ErrUnroutable, p.returned.ReplyCode, p.returned.ReplyText)
+	default:
+		p.done <- nil
+	}
+}
+
+// close fails the publishes still waiting, their confirmations will never arrive
+func (c *confirmer) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for tag, p := range c.pending {
+		p.done <- ErrChannelClosed
+		delete(c.pending, tag)
+	}
+}
+
+// newMessageID returns a random ID identifying a message in the returns of the broker
+func newMessageID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,62 @@
+package rabbitmq
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+// pendingConfirmer returns a confirmer waiting for the confirmation of the given message IDs, tagged from 1
+func pendingConfirmer(ids ...string) (*confirmer, []chan error) {
+	c := &confirmer{pending: map[uint64]*pendingPublish{}}
+	var done []chan error
+	for _, id := range ids {
+		c.nextTag++
+		done = append(done, make(chan error, 1))
+		c.pending[c.nextTag] = &pendingPublish{messageID: id, done: done[len(done)-1]}
+	}
+	return c, done
+}
+
+func TestConfirmerSettlesPublishes(t *testing.T) {
+	c, done := pendingConfirmer("a", "b", "c")
+
+	// O retorno chega antes do ack da mesma mensagem
+	c.returned(amqp.Return{MessageId: "b", ReplyCode: 312, ReplyText: "NO_ROUTE"})
+	c.confirm(amqp.Confirmation{DeliveryTag: 1, Ack: true})
+	c.confirm(amqp.Confirmation{DeliveryTag: 2, Ack: true})
+	c.confirm(amqp.Confirmation{DeliveryTag: 3, Ack: false})
+
+	assert.NoError(t, <-done[0])
+	err := <-done[1]
+	assert.ErrorIs(t, err, ErrUnroutable)
+	assert.Contains(t, err.Error(), "312 NO_ROUTE")
+	assert.ErrorIs(t, <-done[2], ErrPublishNacked)
+	assert.Empty(t, c.pending)
+}
+
+func TestConfirmerListenRecordsReturnBeforeAck(t *testing.T) {
+	// O select escolhe ao acaso entre os canais prontos, repete para cobrir as duas ordens
+	for i := 0; i < 50; i++ {
+		c, done := pendingConfirmer("a")
+		confirms := make(chan amqp.Confirmation, 1)
+		returns := make(chan amqp.Return, 1)
+		returns <- amqp.Return{MessageId: "a", ReplyCode: 312, ReplyText: "NO_ROUTE"}
+		confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+		close(confirms)
+
+		c.listen(confirms, returns)
+		assert.ErrorIs(t, <-done[0], ErrUnroutable)
+	}
+}
+
+func TestConfirmerFailsPendingOnClose(t *testing.T) {
+	c, done := pendingConfirmer("a")
+	c.forget(99) // Tags that gave up waiting are ignored
+	c.confirm(amqp.Confirmation{DeliveryTag: 99, Ack: true})
+
+	c.close()
+	assert.ErrorIs(t, <-done[0], ErrChannelClosed)
+	assert.True(t, c.closed)
+}
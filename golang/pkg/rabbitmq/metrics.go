@@ -0,0 +1,36 @@
+package rabbitmq
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics of the RabbitClient, registered in the default Prometheus registry
+var (
+	messagesPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rabbitmq",
+		Name:      "messages_published_total",
+		Help:      "Messages published, by exchange and routing key.",
+	}, []string{"exchange", "routing_key"})
+	publishErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rabbitmq",
+		Name:      "publish_errors_total",
+		Help:      "Messages that could not be published, by exchange and routing key.",
+	}, []string{"exchange", "routing_key"})
+	publishDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "rabbitmq",
+		Name:      "publish_duration_seconds",
+		Help:      "Time to publish a message and receive its confirmation.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"exchange", "routing_key"})
+	messagesRetried = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rabbitmq",
+		Name:      "messages_retried_total",
+		Help:      "Deliveries republished by Retry and Park, by queue and outcome (retry, dead_letter or parked).",
+	}, []string{"queue", "outcome"})
+	reconnections = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "rabbitmq",
+		Name:      "reconnections_total",
+		Help:      "Times the client reconnected after losing the connection or the channel.",
+	})
+)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/streadway/amqp"
@@ -12,16 +13,29 @@ import (
 // RabbitClientInterface defines the interface for RabbitMQ operations, making it easier to mock in tests
 type RabbitClientInterface interface {
 	ConsumeMessages(exchange, routingKey, queueName string) (<-chan amqp.Delivery, error)
-	PublishMessage(exchange, routingKey, queueName string, message []byte) error // Alterado para aceitar o nome da fila
+	PublishMessage(ctx context.Context, exchange, routingKey, queueName string, message []byte, headers amqp.Table) error // Alterado para aceitar o nome da fila
+	Retry(d amqp.Delivery, queueName string, policy RetryPolicy, retryable bool, reason string) (bool, error)
+	Park(d amqp.Delivery, queueName string, reason string) error
 	Close() error
 	IsClosed() bool
 }
 
-// RabbitClient manages RabbitMQ connections
+// RabbitClient manages RabbitMQ connections. It reconnects on its own when the connection or the channel
+// is closed, declaring the topology again and resuming the consumers, until it is closed or its context is done.
 type RabbitClient struct {
-	conn    *amqp.Connection
-	channel *amqp.Channel
-	url     string
+	url            string
+	prefetch       int
+	confirmTimeout time.Duration
+	ctx            context.Context // Lifetime of the client
+	cancel         context.CancelFunc
+
+	mu        sync.RWMutex
+	conn      *amqp.Connection
+	channel   *amqp.Channel
+	confirmer *confirmer                  // Publishes on channel, which is in confirm mode
+	ready     chan struct{}               // Closed while connected, replaced when the connection is lost
+	topology  []func(*amqp.Channel) error // Declarations replayed after reconnecting
+	declared  map[string]bool             // Exchanges, queues and bindings already declared, see exchangeKey
 }
 
 // newConnection establishes a new connection and channel with RabbitMQ
@@ -40,115 +54,308 @@ func newConnection(url string) (*amqp.Connection, *amqp.Channel, error) {
 	return conn, channel, nil
 }
 
-// NewRabbitClient creates a new RabbitMQ client with the given connection URL
+// NewRabbitClient creates a new RabbitMQ client with the given connection URL.
+// The client stops reconnecting and closes its delivery streams once ctx is done.
 func NewRabbitClient(ctx context.Context, connectionURL string) (*RabbitClient, error) {
 	conn, channel, err := newConnection(connectionURL)
 	if err != nil {
 		return nil, err
 	}
+	confirmer, err := newConfirmer(channel)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
 
-	return &RabbitClient{
-		conn:    conn,
-		channel: channel,
-		url:     connectionURL,
-	}, nil
+	client := &RabbitClient{
+		conn:           conn,
+		channel:        channel,
+		confirmer:      confirmer,
+		url:            connectionURL,
+		confirmTimeout: DefaultConfirmTimeout,
+		ready:          make(chan struct{}),
+		declared:       map[string]bool{},
+	}
+	client.ctx, client.cancel = context.WithCancel(ctx)
+	close(client.ready)
+	go client.supervise(conn, channel)
+	return client, nil
 }
 
-// ConsumeMessages consumes messages from a specified exchange using a custom queue name and routing key
-func (client *RabbitClient) ConsumeMessages(exchange, routingKey, queueName string) (<-chan amqp.Delivery, error) {
-	err := client.channel.ExchangeDeclare(
-		exchange, "direct", true, true, false, false, nil)
+// SetPrefetch limits the number of unacknowledged deliveries the broker sends to the consumers
+// of this client, leaving the remaining messages in the queue for other consumers. Zero means unlimited.
+func (client *RabbitClient) SetPrefetch(count int) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.prefetch = count
+}
+
+// SetConfirmTimeout sets how long a publish waits for the broker to confirm it before failing
+func (client *RabbitClient) SetConfirmTimeout(timeout time.Duration) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.confirmTimeout = timeout
+}
+
+// publish publishes a mandatory message on the current channel and waits for the broker to confirm it
+func (client *RabbitClient) publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	client.mu.RLock()
+	confirmer, timeout := client.confirmer, client.confirmTimeout
+	client.mu.RUnlock()
+	return confirmer.publish(ctx, exchange, routingKey, msg, timeout)
+}
+
+// currentChannel returns the channel of the current connection, which may be closed while reconnecting
+func (client *RabbitClient) currentChannel() *amqp.Channel {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	return client.channel
+}
+
+// declare runs a topology declaration on the current channel and, when it succeeds, records it
+// so that it is declared again after reconnecting
+func (client *RabbitClient) declare(declaration func(*amqp.Channel) error) error {
+	if err := declaration(client.currentChannel()); err != nil {
+		return err
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.topology = append(client.topology, declaration)
+	return nil
+}
+
+// ensureQueue declares the exchange, the queue and the binding used by ConsumeMessages and PublishMessage,
+// skipping the ones already declared by a previous call or by DeclareTopology
+func (client *RabbitClient) ensureQueue(exchange, routingKey, queueName string) error {
+	client.mu.RLock()
+	missing := queueDeclaration{
+		exchange: !client.declared[exchangeKey(exchange)],
+		queue:    !client.declared[queueKey(queueName)],
+		binding:  !client.declared[bindingKey(exchange, routingKey, queueName)],
+	}
+	client.mu.RUnlock()
+	if !missing.exchange && !missing.queue && !missing.binding {
+		return nil
+	}
+
+	err := client.declare(func(channel *amqp.Channel) error {
+		return declareQueue(channel, exchange, routingKey, queueName, missing)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to declare exchange: %v", err)
+		return err
 	}
 
-	queue, err := client.channel.QueueDeclare(
-		queueName, true, true, false, false, nil)
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.declared[exchangeKey(exchange)] = true
+	client.declared[queueKey(queueName)] = true
+	client.declared[bindingKey(exchange, routingKey, queueName)] = true
+	return nil
+}
+
+// queueDeclaration tells which parts declareQueue declares
+type queueDeclaration struct {
+	exchange, queue, binding bool
+}
+
+// declareQueue declares a durable direct exchange and a durable auto-delete queue, and binds them with
+// the routing key. The exchange is not auto-delete, as declared by Django and the topology file.
+func declareQueue(channel *amqp.Channel, exchange, routingKey, queueName string, parts queueDeclaration) error {
+	if parts.exchange {
+		err := channel.ExchangeDeclare(
+			exchange, "direct", true, false, false, false, nil)
+		if err != nil {
+			return fmt.Errorf("failed to declare exchange: %v", err)
+		}
+	}
+
+	if parts.queue {
+		_, err := channel.QueueDeclare(
+			queueName, true, true, false, false, nil)
+		if err != nil {
+			return fmt.Errorf("failed to declare queue: %v", err)
+		}
+	}
+
+	if parts.binding {
+		err := channel.QueueBind(queueName, routingKey, exchange, false, nil)
+		if err != nil {
+			return fmt.Errorf("failed to bind queue: %v", err)
+		}
+	}
+	return nil
+}
+
+// ConsumeMessages consumes messages from a specified exchange using a custom queue name and routing key.
+// The returned stream survives reconnections: the consumer is registered again on the new channel.
+// It is closed once the client is closed or its context is done.
+func (client *RabbitClient) ConsumeMessages(exchange, routingKey, queueName string) (<-chan amqp.Delivery, error) {
+	if err := client.ensureQueue(exchange, routingKey, queueName); err != nil {
+		return nil, err
+	}
+
+	msgs, err := client.consume(queueName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to declare queue: %v", err)
+		return nil, err
 	}
 
-	err = client.channel.QueueBind(queue.Name, routingKey, exchange, false, nil)
+	out := make(chan amqp.Delivery)
+	go client.forward(queueName, msgs, out)
+	return out, nil
+}
+
+// consume registers a consumer of the queue on the current channel
+func (client *RabbitClient) consume(queueName string) (<-chan amqp.Delivery, error) {
+	client.mu.RLock()
+	channel, prefetch := client.channel, client.prefetch
+	client.mu.RUnlock()
+
+	err := channel.Qos(prefetch, 0, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to bind queue: %v", err)
+		return nil, fmt.Errorf("failed to set prefetch: %v", err)
 	}
 
-	msgs, err := client.channel.Consume(queue.Name, "", false, false, false, false, nil)
+	msgs, err := channel.Consume(queueName, "", false, false, false, false, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to consume messages: %v", err)
 	}
-
 	return msgs, nil
 }
 
-// PublishMessage publishes a message to a specified exchange and binds it to a queue
-func (client *RabbitClient) PublishMessage(exchange, routingKey, queueName string, message []byte) error {
-	// Ensure the exchange exists before publishing
-	err := client.channel.ExchangeDeclare(
-		exchange, "direct", true, true, false, false, nil)
-	if err != nil {
-		return fmt.Errorf("failed to declare exchange: %v", err)
+// forward copies the deliveries of the consumer to out. When the channel is closed it waits for the
+// client to reconnect and consumes the queue again.
+func (client *RabbitClient) forward(queueName string, msgs <-chan amqp.Delivery, out chan<- amqp.Delivery) {
+	defer close(out)
+	for {
+		for d := range msgs {
+			select {
+			case out <- d:
+			case <-client.ctx.Done():
+				return
+			}
+		}
+
+		for {
+			if !client.waitReady() {
+				return
+			}
+			var err error
+			msgs, err = client.consume(queueName)
+			if err == nil {
+				slog.Info("Resumed consuming from RabbitMQ", slog.String("queue", queueName))
+				break
+			}
+			// O supervisor ainda pode não ter percebido a queda da conexão
+			slog.Warn("Failed to resume consuming", slog.String("queue", queueName), slog.String("error", err.Error()))
+			select {
+			case <-client.ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
 	}
+}
 
-	// Ensure the queue exists
-	_, err = client.channel.QueueDeclare(
-		queueName, true, true, false, false, nil)
-	if err != nil {
-		return fmt.Errorf("failed to declare queue: %v", err)
+// waitReady blocks until the client is connected, reporting false when the client is closed first
+func (client *RabbitClient) waitReady() bool {
+	client.mu.RLock()
+	ready := client.ready
+	client.mu.RUnlock()
+
+	select {
+	case <-ready:
+		return true
+	case <-client.ctx.Done():
+		return false
 	}
+}
 
-	// Bind the queue to the exchange with the routing key
-	err = client.channel.QueueBind(queueName, routingKey, exchange, false, nil)
+// PublishMessage publishes a message to a specified exchange and binds it to a queue.
+// It returns once the broker has confirmed the message, failing when the message is nacked, cannot be
+// routed to a queue or is not confirmed in time. The message carries headers, which may be nil, and the trace
+// context of ctx.
+func (client *RabbitClient) PublishMessage(ctx context.Context, exchange, routingKey, queueName string, message []byte, headers amqp.Table) (err error) {
+	ctx, span := startPublishSpan(ctx, exchange, routingKey)
+	defer func() { endSpan(span, err) }()
+	published := amqp.Table{}
+	for key, value := range headers {
+		published[key] = value
+	}
+	InjectTraceContext(ctx, published)
+
+	start := time.Now()
+	err = client.publishMessage(ctx, exchange, routingKey, queueName, message, published)
+	publishDuration.WithLabelValues(exchange, routingKey).Observe(time.Since(start).Seconds())
 	if err != nil {
-		return fmt.Errorf("failed to bind queue to exchange: %v", err)
+		publishErrors.WithLabelValues(exchange, routingKey).Inc()
+		return err
+	}
+	messagesPublished.WithLabelValues(exchange, routingKey).Inc()
+	return nil
+}
+
+func (client *RabbitClient) publishMessage(ctx context.Context, exchange, routingKey, queueName string, message []byte, headers amqp.Table) error {
+	// Ensure the exchange and the queue exist before the first publish
+	if err := client.ensureQueue(exchange, routingKey, queueName); err != nil {
+		return err
 	}
 
 	// Publish the message to the exchange with the routing key
-	err = client.channel.Publish(
-		exchange, routingKey, false, false, amqp.Publishing{
-			ContentType: "application/json",
-			Body:        message,
-		})
+	err := client.publish(ctx, exchange, routingKey, amqp.Publishing{
+		ContentType: "application/json",
+		Headers:     headers,
+		Body:        message,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to publish message: %v", err)
 	}
 	return nil
 }
 
-// IsClosed checks if the RabbitMQ connection is closed
+// QueueDepth returns the number of messages ready to be delivered from the queue.
+// It inspects the queue on a channel of its own, since inspecting a missing queue closes the channel.
+func (client *RabbitClient) QueueDepth(queueName string) (int, error) {
+	client.mu.RLock()
+	conn := client.conn
+	client.mu.RUnlock()
+
+	channel, err := conn.Channel()
+	if err != nil {
+		return 0, fmt.Errorf("failed to open channel: %v", err)
+	}
+	defer channel.Close()
+
+	queue, err := channel.QueueInspect(queueName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect queue: %v", err)
+	}
+	return queue.Messages, nil
+}
+
+// IsClosed checks if the RabbitMQ connection is closed, e.g. while the client is reconnecting
 func (client *RabbitClient) IsClosed() bool {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
 	return client.conn.IsClosed()
 }
 
-// Close terminates the RabbitMQ connection and channel
+// Close stops the reconnections and terminates the RabbitMQ connection and channel.
+// The delivery streams are closed and the client cannot be used afterwards.
 func (client *RabbitClient) Close() error {
+	client.cancel()
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.conn.IsClosed() {
+		return nil
+	}
 	err := client.channel.Close()
-	if err != nil {
+	if err != nil && err != amqp.ErrClosed {
 		return fmt.Errorf("failed to close channel: %v", err)
 	}
 	err = client.conn.Close()
-	if err != nil {
+	if err != nil && err != amqp.ErrClosed {
 		return fmt.Errorf("failed to close connection: %v", err)
 	}
 	return nil
 }
-
-// Reconnect attempts to reconnect to RabbitMQ in case of a lost connection
-func (client *RabbitClient) Reconnect(ctx context.Context) error {
-	var err error
-	for {
-		select {
-		case <-ctx.Done():
-			return fmt.Errorf("context canceled while trying to reconnect")
-		default:
-			slog.Info("Attempting to reconnect to RabbitMQ...")
-			client.conn, client.channel, err = newConnection(client.url)
-			if err == nil {
-				slog.Info("Reconnected to RabbitMQ successfully")
-				return nil
-			}
-			slog.Error("Failed to reconnect to RabbitMQ", slog.String("error", err.Error()))
-			time.Sleep(5 * time.Second) // Retry every 5 seconds
-		}
-	}
-}
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/streadway/amqp"
 	"github.com/stretchr/testify/assert"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
@@ -71,7 +72,7 @@ func TestRabbitMQPublishAndConsume(t *testing.T) {
 	// Test case 1: Successfully publish a message
 	t.Run("Publish and consume a valid message", func(t *testing.T) {
 		testMessage := []byte("Hello, RabbitMQ!")
-		err = client.PublishMessage(exchange, routingKey, queueName, testMessage) // Adjusted to include queueName
+		err = client.PublishMessage(ctx, exchange, routingKey, queueName, testMessage, nil) // Adjusted to include queueName
 		assert.NoError(t, err, "Failed to publish message")
 
 		// Wait for the message or timeout
@@ -99,21 +100,16 @@ func TestRabbitMQPublishAndConsume(t *testing.T) {
 		}
 	})
 
-	// Test case 3: Check reconnection logic
+	// Test case 3: The delivery stream survives a reconnection
 	t.Run("Reconnect on connection failure", func(t *testing.T) {
-		client.Close()
-
 		err := client.Reconnect(ctx)
 		assert.NoError(t, err, "Failed to reconnect to RabbitMQ")
+		assert.False(t, client.IsClosed())
 
-		// Re-declare exchange and queue after reconnect
-		msgs, err = client.ConsumeMessages(exchange, routingKey, queueName)
-		assert.NoError(t, err, "Failed to consume messages after reconnect")
-
-		err = client.PublishMessage(exchange, routingKey, queueName, []byte("Reconnected Message")) // Adjusted to include queueName
+		err = client.PublishMessage(ctx, exchange, routingKey, queueName, []byte("Reconnected Message"), nil) // Adjusted to include queueName
 		assert.NoError(t, err, "Failed to publish message after reconnect")
 
-		// Wait for the message or timeout
+		// A mensagem chega pelo mesmo canal de entregas, sem consumir de novo
 		select {
 		case msg := <-msgs:
 			assert.Equal(t, "Reconnected Message", string(msg.Body), "Message mismatch after reconnect")
@@ -122,4 +118,134 @@ func TestRabbitMQPublishAndConsume(t *testing.T) {
 			t.Fatal("Timed out waiting for message after reconnect")
 		}
 	})
+
+	// Test case 4: Closing the client closes the delivery stream
+	t.Run("Close ends the delivery stream", func(t *testing.T) {
+		assert.NoError(t, client.Close())
+
+		select {
+		case _, ok := <-msgs:
+			assert.False(t, ok, "Expected the delivery stream to be closed")
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the delivery stream to close")
+		}
+	})
+}
+
+// TestRabbitMQRetryAndDeadLetter tests the delayed redelivery of a failed message and its move to the dead-letter queue
+func TestRabbitMQRetryAndDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	rabbitmqC, rabbitMQURL, err := startRabbitMQContainer(ctx)
+	assert.NoError(t, err, "Failed to start RabbitMQ container")
+	defer rabbitmqC.Terminate(ctx)
+
+	client, err := rabbitmq.NewRabbitClient(ctx, rabbitMQURL)
+	assert.NoError(t, err, "Failed to connect to RabbitMQ")
+	defer client.Close()
+
+	exchange := "retry_exchange"
+	routingKey := "retry_key"
+	queueName := "retry_queue"
+	policy := rabbitmq.RetryPolicy{MaxAttempts: 2, InitialDelay: 200 * time.Millisecond, MaxDelay: time.Second, Multiplier: 2}
+
+	msgs, err := client.ConsumeMessages(exchange, routingKey, queueName)
+	assert.NoError(t, err)
+	assert.NoError(t, client.DeclareRetryTopology(exchange, routingKey, queueName, policy))
+
+	// A dead-letter queue é durável, então é consumida diretamente
+	conn, err := amqp.Dial(rabbitMQURL)
+	assert.NoError(t, err)
+	defer conn.Close()
+	channel, err := conn.Channel()
+	assert.NoError(t, err)
+	dead, err := channel.Consume(rabbitmq.DeadLetterQueueName(queueName), "", false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	assert.NoError(t, client.PublishMessage(ctx, exchange, routingKey, queueName, []byte("retry me"), amqp.Table{"x-message-type": "retry"}))
+
+	// Primeira falha: a mensagem volta após o atraso da política
+	msg := <-msgs
+	isDead, err := client.Retry(msg, queueName, policy, true, "transient")
+	assert.NoError(t, err)
+	assert.False(t, isDead)
+
+	select {
+	case msg = <-msgs:
+		assert.Equal(t, 1, rabbitmq.RetryCount(msg))
+		assert.Equal(t, "retry", msg.Headers["x-message-type"])
+		assert.Equal(t, "retry me", string(msg.Body))
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the retried message")
+	}
+
+	// Segunda falha: tentativas esgotadas, vai para a dead-letter queue
+	isDead, err = client.Retry(msg, queueName, policy, true, "transient again")
+	assert.NoError(t, err)
+	assert.True(t, isDead)
+
+	select {
+	case msg = <-dead:
+		assert.Equal(t, "transient again", msg.Headers[rabbitmq.LastErrorHeader])
+		msg.Ack(false)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the dead-lettered message")
+	}
+
+	// Mensagens que o consumidor não entende vão para a parking queue, com os headers originais
+	parked, err := channel.Consume(rabbitmq.ParkingQueueName(queueName), "", false, false, false, false, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, client.PublishMessage(ctx, exchange, routingKey, queueName, []byte("park me"), amqp.Table{"x-message-type": "unknown"}))
+	assert.NoError(t, client.Park(<-msgs, queueName, "unknown message"))
+
+	select {
+	case msg = <-parked:
+		assert.Equal(t, "park me", string(msg.Body))
+		assert.Equal(t, "unknown", msg.Headers["x-message-type"])
+		assert.Equal(t, "unknown message", msg.Headers[rabbitmq.LastErrorHeader])
+		msg.Ack(false)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the parked message")
+	}
+}
+
+func TestRabbitMQDeclareTopology(t *testing.T) {
+	ctx := context.Background()
+	rabbitmqC, rabbitMQURL, err := startRabbitMQContainer(ctx)
+	assert.NoError(t, err, "Failed to start RabbitMQ container")
+	defer rabbitmqC.Terminate(ctx)
+
+	client, err := rabbitmq.NewRabbitClient(ctx, rabbitMQURL)
+	assert.NoError(t, err, "Failed to connect to RabbitMQ")
+	defer client.Close()
+
+	topology := rabbitmq.Topology{
+		Exchanges: []rabbitmq.ExchangeSpec{{Name: "durable_exchange", Type: "direct", Durable: true}},
+		Queues:    []rabbitmq.QueueSpec{{Name: "durable_queue", Type: rabbitmq.QueueQuorum, Durable: true}},
+		Bindings:  []rabbitmq.BindingSpec{{Queue: "durable_queue", Exchange: "durable_exchange", RoutingKey: "durable_key"}},
+	}
+	assert.NoError(t, client.DeclareTopology(topology))
+
+	// A fila declarada pela topologia é usada como está, sem a declaração padrão com auto-delete
+	msgs, err := client.ConsumeMessages("durable_exchange", "durable_key", "durable_queue")
+	assert.NoError(t, err)
+	assert.NoError(t, client.Reconnect(ctx))
+	assert.NoError(t, client.PublishMessage(ctx, "durable_exchange", "durable_key", "durable_queue", []byte("durable"), nil))
+
+	select {
+	case msg := <-msgs:
+		assert.Equal(t, "durable", string(msg.Body))
+		msg.Ack(false)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the message")
+	}
+
+	// A fila quorum sobrevive sem consumidores
+	assert.NoError(t, client.Close())
+	conn, err := amqp.Dial(rabbitMQURL)
+	assert.NoError(t, err)
+	defer conn.Close()
+	channel, err := conn.Channel()
+	assert.NoError(t, err)
+	_, err = channel.QueueInspect("durable_queue")
+	assert.NoError(t, err)
 }
@@ -0,0 +1,147 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Backoff between reconnection attempts
+const (
+	reconnectBaseDelay = time.Second
+	reconnectMaxDelay  = 30 * time.Second
+)
+
+// reconnectDelay returns how long to wait before the given reconnection attempt, starting at 0.
+// The delay doubles up to max and half of it is random, so that the replicas do not reconnect
+// all at once after a broker restart.
+func reconnectDelay(attempt int, base, max time.Duration) time.Duration {
+	delay := max
+	if attempt < 32 && base<<attempt > 0 && base<<attempt < max {
+		delay = base << attempt
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// supervise watches the connection and the channel of the client, reconnecting when either is closed
+func (client *RabbitClient) supervise(conn *amqp.Connection, channel *amqp.Channel) {
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	channelClosed := channel.NotifyClose(make(chan *amqp.Error, 1))
+	for {
+		var reason *amqp.Error
+		select {
+		case <-client.ctx.Done():
+			return
+		case reason = <-connClosed:
+		case reason = <-channelClosed:
+		}
+		if client.ctx.Err() != nil {
+			return
+		}
+
+		client.markDown()
+		conn.Close() // Only the channel may have been closed
+		if reason != nil {
+			slog.Warn("RabbitMQ connection lost", slog.String("error", reason.Error()))
+		} else {
+			slog.Warn("RabbitMQ connection lost")
+		}
+
+		var confirmer *confirmer
+		var err error
+		for attempt := 0; ; attempt++ {
+			conn, channel, confirmer, err = client.connect()
+			if err == nil {
+				break
+			}
+			delay := reconnectDelay(attempt, reconnectBaseDelay, reconnectMaxDelay)
+			slog.Error("Failed to reconnect to RabbitMQ", slog.String("error", err.Error()), slog.Duration("retry_in", delay))
+			select {
+			case <-client.ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+		connClosed = conn.NotifyClose(make(chan *amqp.Error, 1))
+		channelClosed = channel.NotifyClose(make(chan *amqp.Error, 1))
+
+		client.mu.Lock()
+		if client.ctx.Err() != nil {
+			client.mu.Unlock()
+			conn.Close()
+			return
+		}
+		client.conn, client.channel, client.confirmer = conn, channel, confirmer
+		close(client.ready)
+		client.mu.Unlock()
+		reconnections.Inc()
+		slog.Info("Reconnected to RabbitMQ successfully")
+	}
+}
+
+// connect opens a new connection and declares the topology recorded by the client on it
+func (client *RabbitClient) connect() (*amqp.Connection, *amqp.Channel, *confirmer, error) {
+	if client.ctx.Err() != nil {
+		return nil, nil, nil, client.ctx.Err()
+	}
+	conn, channel, err := newConnection(client.url)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	confirmer, err := newConfirmer(channel)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+
+	client.mu.RLock()
+	topology, prefetch := client.topology, client.prefetch
+	client.mu.RUnlock()
+
+	err = channel.Qos(prefetch, 0, false)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf("failed to set prefetch: %v", err)
+	}
+	for _, declaration := range topology {
+		if err := declaration(channel); err != nil {
+			conn.Close()
+			return nil, nil, nil, err
+		}
+	}
+	return conn, channel, confirmer, nil
+}
+
+// markDown makes the client wait for the next connection, unless it is already waiting
+func (client *RabbitClient) markDown() {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	select {
+	case <-client.ready:
+		client.ready = make(chan struct{})
+	default:
+	}
+}
+
+// Reconnect drops the current connection and waits until the client has reconnected, as it does on its
+// own after a broker restart. The delivery streams keep working on the new connection.
+func (client *RabbitClient) Reconnect(ctx context.Context) error {
+	client.markDown()
+	client.mu.RLock()
+	conn, ready := client.conn, client.ready
+	client.mu.RUnlock()
+	conn.Close()
+
+	select {
+	case <-ready:
+		return nil
+	case <-client.ctx.Done():
+		return fmt.Errorf("client closed while trying to reconnect")
+	case <-ctx.Done():
+		return fmt.Errorf("context canceled while trying to reconnect")
+	}
+}
@@ -0,0 +1,25 @@
+package rabbitmq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		for range 20 {
+			delay := reconnectDelay(attempt, time.Second, 30*time.Second)
+			assert.GreaterOrEqual(t, delay, expected/2)
+			assert.LessOrEqual(t, delay, expected)
+		}
+	}
+
+	// O atraso é limitado por max, inclusive quando o deslocamento estouraria
+	for _, attempt := range []int{5, 40, 100} {
+		delay := reconnectDelay(attempt, time.Second, 30*time.Second)
+		assert.GreaterOrEqual(t, delay, 15*time.Second)
+		assert.LessOrEqual(t, delay, 30*time.Second)
+	}
+}
@@ -0,0 +1,190 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Headers carried by the messages republished for a retry
+const (
+	RetryCountHeader = "x-retry-count"
+	LastErrorHeader  = "x-last-error"
+)
+
+// RetryPolicy controls how many times a message is attempted and how long to wait between attempts
+type RetryPolicy struct {
+	MaxAttempts  int           // Total attempts, including the first delivery
+	InitialDelay time.Duration // Delay before the second attempt
+	MaxDelay     time.Duration // Upper bound of the delay between attempts
+	Multiplier   float64       // Growth of the delay after each attempt
+}
+
+// DefaultRetryPolicy retries 5 times, waiting 10s, 30s, 90s and 270s
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:  5,
+		InitialDelay: 10 * time.Second,
+		MaxDelay:     30 * time.Minute,
+		Multiplier:   3,
+	}
+}
+
+// Delay returns how long to wait before the given retry, starting at 1
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	delay := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(retry-1))
+	if delay > float64(p.MaxDelay) {
+		return p.MaxDelay
+	}
+	return time.Duration(delay)
+}
+
+// RetryExchange is the exchange routing retried messages to the delay and dead-letter queues
+func RetryExchange(exchange string) string {
+	return exchange + ".retry"
+}
+
+// DelayQueueName is the queue holding the messages waiting for the given retry of queueName
+func DelayQueueName(queueName string, retry int) string {
+	return fmt.Sprintf("%s.retry.%d", queueName, retry)
+}
+
+// DeadLetterQueueName is the queue receiving the messages of queueName that will not be retried
+func DeadLetterQueueName(queueName string) string {
+	return queueName + ".dead"
+}
+
+// ParkingQueueName is the queue holding the messages of queueName the consumer does not understand,
+// e.g. of a newer version, until they are moved back by hand
+func ParkingQueueName(queueName string) string {
+	return queueName + ".parking"
+}
+
+// RetryCount returns how many times the delivery has already been retried
+func RetryCount(d amqp.Delivery) int {
+	switch count := d.Headers[RetryCountHeader].(type) {
+	case int32:
+		return int(count)
+	case int64:
+		return int(count)
+	case int:
+		return count
+	}
+	return 0
+}
+
+// DeclareRetryTopology declares, for a queue bound to exchange with routingKey, one delay queue per retry,
+// the dead-letter queue and the parking queue. Each delay queue holds the messages for the backoff of its retry
+// (x-message-ttl) and then dead-letters them back to exchange with routingKey (x-dead-letter-exchange).
+func (client *RabbitClient) DeclareRetryTopology(exchange, routingKey, queueName string, policy RetryPolicy) error {
+	return client.declare(func(channel *amqp.Channel) error {
+		return declareRetryTopology(channel, exchange, routingKey, queueName, policy)
+	})
+}
+
+func declareRetryTopology(channel *amqp.Channel, exchange, routingKey, queueName string, policy RetryPolicy) error {
+	retryExchange := RetryExchange(exchange)
+	err := channel.ExchangeDeclare(retryExchange, "direct", true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to declare retry exchange: %v", err)
+	}
+
+	for retry := 1; retry < policy.MaxAttempts; retry++ {
+		delayQueue := DelayQueueName(queueName, retry)
+		_, err = channel.QueueDeclare(delayQueue, true, false, false, false, amqp.Table{
+			"x-message-ttl":             int64(policy.Delay(retry) / time.Millisecond),
+			"x-dead-letter-exchange":    exchange,
+			"x-dead-letter-routing-key": routingKey,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to declare delay queue %s: %v", delayQueue, err)
+		}
+		if err = channel.QueueBind(delayQueue, delayQueue, retryExchange, false, nil); err != nil {
+			return fmt.Errorf("failed to bind delay queue %s: %v", delayQueue, err)
+		}
+	}
+
+	deadQueue := DeadLetterQueueName(queueName)
+	if _, err = channel.QueueDeclare(deadQueue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare dead-letter queue: %v", err)
+	}
+	if err = channel.QueueBind(deadQueue, deadQueue, retryExchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind dead-letter queue: %v", err)
+	}
+
+	parkingQueue := ParkingQueueName(queueName)
+	if _, err = channel.QueueDeclare(parkingQueue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare parking queue: %v", err)
+	}
+	if err = channel.QueueBind(parkingQueue, parkingQueue, retryExchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind parking queue: %v", err)
+	}
+	return nil
+}
+
+// Retry republishes a failed delivery of queueName to the delay queue of its next attempt, or to the
+// dead-letter queue when it is not retryable or has exhausted the policy, and then acks it.
+// It reports whether the message was dead-lettered. The topology must have been declared with DeclareRetryTopology.
+func (client *RabbitClient) Retry(d amqp.Delivery, queueName string, policy RetryPolicy, retryable bool, reason string) (bool, error) {
+	retry := RetryCount(d) + 1
+	dead := !retryable || retry >= policy.MaxAttempts
+
+	routingKey := DelayQueueName(queueName, retry)
+	if dead {
+		routingKey = DeadLetterQueueName(queueName)
+	}
+
+	headers := amqp.Table{}
+	for key, value := range d.Headers {
+		headers[key] = value
+	}
+	headers[RetryCountHeader] = int32(retry)
+	headers[LastErrorHeader] = reason
+
+	err := client.publish(context.Background(), RetryExchange(d.Exchange), routingKey, amqp.Publishing{
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Headers:      headers,
+		Body:         d.Body,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to publish retry: %v", err)
+	}
+
+	if dead {
+		messagesRetried.WithLabelValues(queueName, "dead_letter").Inc()
+		slog.Warn("Message moved to dead-letter queue", slog.String("queue", queueName), slog.Int("attempts", retry), slog.String("reason", reason))
+	} else {
+		messagesRetried.WithLabelValues(queueName, "retry").Inc()
+		slog.Info("Message scheduled for retry", slog.String("queue", queueName), slog.Int("retry", retry), slog.Duration("delay", policy.Delay(retry)))
+	}
+	return dead, d.Ack(false)
+}
+
+// Park republishes a delivery of queueName to its parking queue, keeping its headers and body, and then acks it.
+// The topology must have been declared with DeclareRetryTopology.
+func (client *RabbitClient) Park(d amqp.Delivery, queueName string, reason string) error {
+	headers := amqp.Table{}
+	for key, value := range d.Headers {
+		headers[key] = value
+	}
+	headers[LastErrorHeader] = reason
+
+	err := client.publish(context.Background(), RetryExchange(d.Exchange), ParkingQueueName(queueName), amqp.Publishing{
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Headers:      headers,
+		Body:         d.Body,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish to the parking queue: %v", err)
+	}
+
+	messagesRetried.WithLabelValues(queueName, "parked").Inc()
+	slog.Warn("Message moved to parking queue", slog.String("queue", queueName), slog.String("reason", reason))
+	return d.Ack(false)
+}
@@ -0,0 +1,32 @@
+package rabbitmq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := DefaultRetryPolicy()
+	assert.Equal(t, 10*time.Second, policy.Delay(1))
+	assert.Equal(t, 30*time.Second, policy.Delay(2))
+	assert.Equal(t, 270*time.Second, policy.Delay(4))
+
+	// O atraso é limitado por MaxDelay
+	policy.MaxDelay = time.Minute
+	assert.Equal(t, time.Minute, policy.Delay(4))
+}
+
+func TestRetryCount(t *testing.T) {
+	assert.Equal(t, 0, RetryCount(amqp.Delivery{}))
+	assert.Equal(t, 2, RetryCount(amqp.Delivery{Headers: amqp.Table{RetryCountHeader: int32(2)}}))
+	assert.Equal(t, 3, RetryCount(amqp.Delivery{Headers: amqp.Table{RetryCountHeader: int64(3)}}))
+}
+
+func TestRetryTopologyNames(t *testing.T) {
+	assert.Equal(t, "conversion_exchange.retry", RetryExchange("conversion_exchange"))
+	assert.Equal(t, "video_conversion_queue.retry.2", DelayQueueName("video_conversion_queue", 2))
+	assert.Equal(t, "video_conversion_queue.dead", DeadLetterQueueName("video_conversion_queue"))
+}
@@ -0,0 +1,249 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Queue types of QueueSpec.Type
+const (
+	QueueClassic = "classic"
+	QueueQuorum  = "quorum"
+)
+
+// Topology lists the exchanges, queues and bindings the client declares once, and again after reconnecting.
+// The queues and exchanges it declares are used as they are by ConsumeMessages and PublishMessage, which
+// otherwise declare a durable auto-delete direct exchange and a durable auto-delete queue.
+type Topology struct {
+	Exchanges []ExchangeSpec `json:"exchanges"`
+	Queues    []QueueSpec    `json:"queues"`
+	Bindings  []BindingSpec  `json:"bindings"`
+}
+
+// ExchangeSpec describes an exchange
+type ExchangeSpec struct {
+	Name       string         `json:"name"`
+	Type       string         `json:"type"` // direct, topic, fanout or headers, direct when empty
+	Durable    bool           `json:"durable"`
+	AutoDelete bool           `json:"auto_delete"`
+	Arguments  map[string]any `json:"arguments,omitempty"`
+}
+
+// QueueSpec describes a queue. The common x- arguments have their own fields, Arguments holds any other.
+type QueueSpec struct {
+	Name                 string         `json:"name"`
+	Type                 string         `json:"type"` // classic or quorum, classic when empty
+	Durable              bool           `json:"durable"`
+	AutoDelete           bool           `json:"auto_delete"`
+	Exclusive            bool           `json:"exclusive"`
+	MessageTTL           Duration       `json:"message_ttl,omitempty"`  // x-message-ttl
+	MaxPriority          int            `json:"max_priority,omitempty"` // x-max-priority, classic queues only
+	DeadLetterExchange   string         `json:"dead_letter_exchange,omitempty"`
+	DeadLetterRoutingKey string         `json:"dead_letter_routing_key,omitempty"`
+	Arguments            map[string]any `json:"arguments,omitempty"`
+}
+
+// BindingSpec binds a queue to an exchange with a routing key
+type BindingSpec struct {
+	Queue      string `json:"queue"`
+	Exchange   string `json:"exchange"`
+	RoutingKey string `json:"routing_key"`
+}
+
+// Duration is a time.Duration written as a string in the topology file, e.g. "30s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %v", err)
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadTopology reads a topology from a JSON file and validates it
+func LoadTopology(path string) (Topology, error) {
+	var topology Topology
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return topology, fmt.Errorf("failed to read topology: %v", err)
+	}
+	if err := json.Unmarshal(data, &topology); err != nil {
+		return topology, fmt.Errorf("failed to parse topology %s: %v", path, err)
+	}
+	if err := topology.Validate(); err != nil {
+		return topology, fmt.Errorf("invalid topology %s: %v", path, err)
+	}
+	return topology, nil
+}
+
+// Validate checks the topology for the settings the broker would refuse
+func (t Topology) Validate() error {
+	var errs []error
+	for _, exchange := range t.Exchanges {
+		if exchange.Name == "" {
+			errs = append(errs, errors.New("exchange without a name"))
+		}
+		switch exchange.Type {
+		case "", amqp.ExchangeDirect, amqp.ExchangeTopic, amqp.ExchangeFanout, amqp.ExchangeHeaders:
+		default:
+			errs = append(errs, fmt.Errorf("exchange %s: unknown type %q", exchange.Name, exchange.Type))
+		}
+	}
+	for _, queue := range t.Queues {
+		if queue.Name == "" {
+			errs = append(errs, errors.New("queue without a name"))
+		}
+		switch queue.Type {
+		case "", QueueClassic:
+		case QueueQuorum:
+			if !queue.Durable || queue.AutoDelete || queue.Exclusive {
+				errs = append(errs, fmt.Errorf("queue %s: a quorum queue must be durable, not auto-delete nor exclusive", queue.Name))
+			}
+			if queue.MaxPriority > 0 {
+				errs = append(errs, fmt.Errorf("queue %s: quorum queues do not support max_priority", queue.Name))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("queue %s: unknown type %q", queue.Name, queue.Type))
+		}
+		if queue.MaxPriority < 0 || queue.MaxPriority > 255 {
+			errs = append(errs, fmt.Errorf("queue %s: max_priority must be between 1 and 255", queue.Name))
+		}
+		if queue.MessageTTL < 0 {
+			errs = append(errs, fmt.Errorf("queue %s: negative message_ttl", queue.Name))
+		}
+	}
+	for _, binding := range t.Bindings {
+		if binding.Queue == "" || binding.Exchange == "" {
+			errs = append(errs, fmt.Errorf("binding of %q to %q needs a queue and an exchange", binding.Queue, binding.Exchange))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// arguments builds the x- arguments of the queue declaration
+func (q QueueSpec) arguments() amqp.Table {
+	args := amqp.Table{}
+	for key, value := range q.Arguments {
+		args[key] = tableValue(value)
+	}
+	if q.Type != "" {
+		args["x-queue-type"] = q.Type
+	}
+	if q.MessageTTL > 0 {
+		args["x-message-ttl"] = int64(time.Duration(q.MessageTTL) / time.Millisecond)
+	}
+	if q.MaxPriority > 0 {
+		args["x-max-priority"] = int32(q.MaxPriority)
+	}
+	if q.DeadLetterExchange != "" {
+		args["x-dead-letter-exchange"] = q.DeadLetterExchange
+	}
+	if q.DeadLetterRoutingKey != "" {
+		args["x-dead-letter-routing-key"] = q.DeadLetterRoutingKey
+	}
+	return args
+}
+
+// tableValue converts a value decoded from JSON to the type the broker expects, JSON numbers being
+// decoded as float64 while arguments such as x-max-length are integers
+func tableValue(value any) any {
+	switch value := value.(type) {
+	case float64:
+		if value == math.Trunc(value) {
+			return int64(value)
+		}
+	case map[string]any:
+		table := amqp.Table{}
+		for key, item := range value {
+			table[key] = tableValue(item)
+		}
+		return table
+	case []any:
+		items := make([]any, len(value))
+		for i, item := range value {
+			items[i] = tableValue(item)
+		}
+		return items
+	}
+	return value
+}
+
+// declareTopology declares the exchanges, then the queues and then the bindings of the topology
+func declareTopology(channel *amqp.Channel, topology Topology) error {
+	for _, exchange := range topology.Exchanges {
+		kind := exchange.Type
+		if kind == "" {
+			kind = amqp.ExchangeDirect
+		}
+		args := amqp.Table{}
+		for key, value := range exchange.Arguments {
+			args[key] = tableValue(value)
+		}
+		err := channel.ExchangeDeclare(exchange.Name, kind, exchange.Durable, exchange.AutoDelete, false, false, args)
+		if err != nil {
+			return fmt.Errorf("failed to declare exchange %s: %v", exchange.Name, err)
+		}
+	}
+	for _, queue := range topology.Queues {
+		_, err := channel.QueueDeclare(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, false, queue.arguments())
+		if err != nil {
+			return fmt.Errorf("failed to declare queue %s: %v", queue.Name, err)
+		}
+	}
+	for _, binding := range topology.Bindings {
+		if err := channel.QueueBind(binding.Queue, binding.RoutingKey, binding.Exchange, false, nil); err != nil {
+			return fmt.Errorf("failed to bind queue %s to %s: %v", binding.Queue, binding.Exchange, err)
+		}
+	}
+	return nil
+}
+
+// DeclareTopology declares the topology, and again after each reconnection. ConsumeMessages and
+// PublishMessage use the exchanges, queues and bindings it declares instead of declaring their defaults.
+func (client *RabbitClient) DeclareTopology(topology Topology) error {
+	if err := topology.Validate(); err != nil {
+		return err
+	}
+	err := client.declare(func(channel *amqp.Channel) error {
+		return declareTopology(channel, topology)
+	})
+	if err != nil {
+		return err
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for _, exchange := range topology.Exchanges {
+		client.declared[exchangeKey(exchange.Name)] = true
+	}
+	for _, queue := range topology.Queues {
+		client.declared[queueKey(queue.Name)] = true
+	}
+	for _, binding := range topology.Bindings {
+		client.declared[bindingKey(binding.Exchange, binding.RoutingKey, binding.Queue)] = true
+	}
+	return nil
+}
+
+// Keys of RabbitClient.declared
+func exchangeKey(name string) string { return "exchange:" + name }
+func queueKey(name string) string    { return "queue:" + name }
+func bindingKey(exchange, routingKey, queue string) string {
+	return "binding:" + exchange + "|" + routingKey + "|" + queue
+}
@@ -0,0 +1,66 @@
+package rabbitmq
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadTopology(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topology.json")
+	err := os.WriteFile(path, []byte(`{
+		"exchanges": [{"name": "conversion_exchange", "type": "direct", "durable": true}],
+		"queues": [
+			{"name": "video_conversion_queue", "type": "quorum", "durable": true, "dead_letter_exchange": "conversion_exchange.retry",
+			 "arguments": {"x-delivery-limit": 10}},
+			{"name": "video_progress_queue", "durable": true, "message_ttl": "1m", "max_priority": 5}
+		],
+		"bindings": [{"queue": "video_conversion_queue", "exchange": "conversion_exchange", "routing_key": "conversion"}]
+	}`), 0o644)
+	assert.NoError(t, err)
+
+	topology, err := LoadTopology(path)
+	assert.NoError(t, err)
+	assert.Len(t, topology.Exchanges, 1)
+	assert.Equal(t, Duration(time.Minute), topology.Queues[1].MessageTTL)
+
+	// Os campos viram argumentos x-, os números do JSON viram inteiros
+	assert.Equal(t, amqp.Table{
+		"x-queue-type":           "quorum",
+		"x-dead-letter-exchange": "conversion_exchange.retry",
+		"x-delivery-limit":       int64(10),
+	}, topology.Queues[0].arguments())
+	assert.Equal(t, amqp.Table{"x-message-ttl": int64(60000), "x-max-priority": int32(5)}, topology.Queues[1].arguments())
+	assert.NoError(t, topology.Queues[0].arguments().Validate())
+
+	_, err = LoadTopology(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestTopologyValidate(t *testing.T) {
+	valid := Topology{
+		Exchanges: []ExchangeSpec{{Name: "conversion_exchange", Durable: true}},
+		Queues:    []QueueSpec{{Name: "video_conversion_queue", Type: QueueQuorum, Durable: true}},
+		Bindings:  []BindingSpec{{Queue: "video_conversion_queue", Exchange: "conversion_exchange", RoutingKey: "conversion"}},
+	}
+	assert.NoError(t, valid.Validate())
+
+	invalid := Topology{
+		Exchanges: []ExchangeSpec{{Name: "conversion_exchange", Type: "x-delayed"}},
+		Queues: []QueueSpec{
+			{Name: "auto_delete_quorum", Type: QueueQuorum, Durable: true, AutoDelete: true},
+			{Name: "priority_quorum", Type: QueueQuorum, Durable: true, MaxPriority: 10},
+			{Name: "stream", Type: "stream"},
+		},
+		Bindings: []BindingSpec{{Queue: "video_conversion_queue"}},
+	}
+	err := invalid.Validate()
+	for _, message := range []string{`unknown type "x-delayed"`, "auto_delete_quorum: a quorum queue must be durable",
+		"priority_quorum: quorum queues do not support max_priority", `stream: unknown type "stream"`, "needs a queue and an exchange"} {
+		assert.ErrorContains(t, err, message)
+	}
+}
@@ -0,0 +1,35 @@
+package rabbitmq
+
+import (
+	"context"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceContextPropagation(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled,
+	}))
+
+	headers := amqp.Table{RetryCountHeader: int32(1)}
+	InjectTraceContext(ctx, headers)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", headers["traceparent"])
+
+	// O consumidor continua o trace do publicador
+	extracted := trace.SpanContextFromContext(ExtractTraceContext(context.Background(), amqp.Delivery{Headers: headers}))
+	assert.Equal(t, traceID, extracted.TraceID())
+	assert.Equal(t, spanID, extracted.SpanID())
+	assert.True(t, extracted.IsRemote())
+
+	// Messages without headers, e.g. from older publishers, start a new trace
+	assert.False(t, trace.SpanContextFromContext(ExtractTraceContext(context.Background(), amqp.Delivery{})).IsValid())
+}
@@ -16,4 +16,5 @@ export type VideoModel = {
     //     avatar: string;
     // }
     video_url: string;
+    hls_url: string | null;
 }
\ No newline at end of file